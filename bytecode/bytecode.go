@@ -13,10 +13,28 @@ type BC struct {
 	Code []*instruction.Inst
 }
 
+// String returns the listing of the instructions, in which the jump targets are written
+// as their positions in the bytecode (e.g. "|01| Split 02, 04"), or "??" if they are not
+// in the bytecode. (see also vm/asm, which can assemble the listing of its own syntax)
 func (bc BC) String() string {
+	index := bc.index()
+	target := func(inst *instruction.Inst) string {
+		if i, ok := index[inst]; ok {
+			return fmt.Sprintf("%02d", i)
+		}
+		return "??"
+	}
+
 	s := ""
 	for i, inst := range bc.Code {
-		s += fmt.Sprintf("|%02d:(%p)| %v", i, inst, inst)
+		switch {
+		case inst != nil && inst.Opcode == opcode.Jmp:
+			s += fmt.Sprintf("|%02d| Jmp %s", i, target(inst.X))
+		case inst != nil && inst.Opcode == opcode.Split:
+			s += fmt.Sprintf("|%02d| Split %s, %s", i, target(inst.X), target(inst.Y))
+		default:
+			s += fmt.Sprintf("|%02d| %v", i, inst)
+		}
 		if i != bc.N-1 {
 			s += "\n"
 		}
@@ -74,111 +92,3 @@ func (bc *BC) AddInst(inst *instruction.Inst, i int) {
 func (bc *BC) PushInst(inst *instruction.Inst) {
 	bc.AddInst(inst, 0)
 }
-
-// Optimize optimizes (or minimize) the bytecode.
-func (bc *BC) Optimize() {
-	bc.removeNOP()
-	bc.optimizeChainedJmp()
-	bc.removeUnreachableInst()
-}
-
-// removeNOP removes NOP instructions from BC to minimize it.
-func (bc *BC) removeNOP() {
-	newBC := NewByteCode()
-	for _, inst := range bc.Code {
-		switch inst.Opcode {
-		case opcode.Jmp:
-			if inst.X.Opcode == opcode.NOP {
-				inst.X = bc.canReachWithOnlyNOP(bc.IndexOf(inst.X))
-			}
-		case opcode.Split:
-			if inst.X.Opcode == opcode.NOP {
-				inst.X = bc.canReachWithOnlyNOP(bc.IndexOf(inst.X))
-			}
-			if inst.Y.Opcode == opcode.NOP {
-				inst.Y = bc.canReachWithOnlyNOP(bc.IndexOf(inst.Y))
-			}
-		}
-		if inst.Opcode != opcode.NOP {
-			newBC.AddInst(inst, newBC.N)
-		}
-	}
-	bc.Code = newBC.Code
-	bc.N = newBC.N
-}
-
-func (bc *BC) canReachWithOnlyNOP(from int) *instruction.Inst {
-	prog := bc.Code
-	for i := from; i < bc.N; i++ {
-		if prog[i].Opcode != opcode.NOP {
-			return prog[i]
-		}
-	}
-	return nil
-}
-
-// optimizeChainedJmp optimizes chained Jmp instruction. (e.g. [00:Jmp 01 -> 01:Jmp 02 -> 02:Jmp 03 -> 03:Jmp Match] to [Jmp 03 -> 03:Jmp Match])
-func (bc *BC) optimizeChainedJmp() {
-	for _, inst := range bc.Code {
-		switch inst.Opcode {
-		case opcode.Jmp:
-			if inst.X.Opcode == opcode.Jmp {
-				inst.X = bc.chainedJmpDst(inst.X)
-			}
-		case opcode.Split:
-			if inst.X.Opcode == opcode.Jmp {
-				inst.X = bc.chainedJmpDst(inst.X)
-			}
-			if inst.Y.Opcode == opcode.Jmp {
-				inst.Y = bc.chainedJmpDst(inst.Y)
-			}
-		}
-	}
-}
-
-// chainedJmpDst returns the instruction which in the end will be reached from the argument instruction.
-func (bc *BC) chainedJmpDst(jmp *instruction.Inst) *instruction.Inst {
-	// Use only for optimizing jmp destination.
-	if jmp.Opcode != opcode.Jmp {
-		panic("the inst opcode is NOT Jmp")
-	}
-
-	// Check recursively.
-	dst := jmp.X
-	for {
-		if dst.Opcode != opcode.Jmp {
-			break
-		}
-		dst = dst.X
-	}
-	return dst
-}
-
-// removeUnreachableInst removes all the unreachable instructions from the bytecode.
-func (bc *BC) removeUnreachableInst() {
-	bc.removeUnreachableJmp()
-}
-
-// removeUnreachableJmp removes unreachable Jmp instruction from the bytecode.
-func (bc *BC) removeUnreachableJmp() {
-	for i := bc.N - 1; i > 0; i-- {
-		if bc.Code[i].Opcode == opcode.Jmp && bc.Code[i-1].Opcode == opcode.Jmp && bc.heldAsOperandCnt(bc.Code[i]) == 0 {
-			bc.Code = append(bc.Code[:i], bc.Code[i+1:]...)
-			bc.N--
-		}
-	}
-}
-
-// heldAsOperandCnt returns how many instructions in bytecode have inst as operand.
-func (bc *BC) heldAsOperandCnt(inst *instruction.Inst) int {
-	cnt := 0
-	for _, inst := range bc.Code {
-		if inst.X == inst {
-			cnt++
-		}
-		if inst.Y == inst {
-			cnt++
-		}
-	}
-	return cnt
-}
@@ -0,0 +1,31 @@
+package bytecode
+
+import (
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// The listing must write the jump targets as the positions, not as the addresses.
+func TestString(t *testing.T) {
+	m := instruction.NewInst(opcode.Match, 0, nil, nil)
+	c := instruction.NewInst(opcode.Char, 'b', nil, nil)
+	outside := instruction.NewInst(opcode.Match, 0, nil, nil)
+
+	bc := NewByteCode()
+	bc.AddInst(instruction.NewInst(opcode.Char, 'a', nil, nil), bc.N)
+	bc.AddInst(instruction.NewInst(opcode.Split, 0, c, m), bc.N)
+	bc.AddInst(c, bc.N)
+	bc.AddInst(instruction.NewInst(opcode.Jmp, 0, outside, nil), bc.N)
+	bc.AddInst(m, bc.N)
+
+	want := "|00| Char 'a'\n" +
+		"|01| Split 02, 04\n" +
+		"|02| Char 'b'\n" +
+		"|03| Jmp ??\n" +
+		"|04| Match"
+	if got := bc.String(); got != want {
+		t.Errorf("String() = \n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package bytecode
+
+import (
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// Optimize optimizes (or minimize) the bytecode by the passes over its control-flow graph.
+// After each pass, it verifies that every jump target exists in the bytecode,
+// and panics if not, because the pass must have broken the bytecode.
+func (bc *BC) Optimize() {
+	// Threading a jump may make a Split collapse, and vice versa.
+	for changed := true; changed; {
+		changed = bc.runPass(bc.threadJmp)
+		changed = bc.runPass(bc.collapseSplit) || changed
+	}
+	bc.runPass(bc.removeDeadCode)
+	bc.runPass(bc.removeNOP)
+	bc.runPass(bc.coalesceChar)
+}
+
+// runPass runs the argument pass over the bytecode, and verifies the result.
+// It returns whether the pass has changed the bytecode.
+func (bc *BC) runPass(pass func() bool) bool {
+	changed := pass()
+	if err := bc.verifyJumps(bc.index()); err != nil {
+		panic(err)
+	}
+	return changed
+}
+
+// index returns the position of each instruction in the bytecode.
+func (bc *BC) index() map[*instruction.Inst]int {
+	index := make(map[*instruction.Inst]int, bc.N)
+	for i, inst := range bc.Code {
+		index[inst] = i
+	}
+	return index
+}
+
+// successors returns the positions of the instructions which can be run next to
+// the instruction at pc, that is its edges in the control-flow graph.
+func (bc *BC) successors(pc int, index map[*instruction.Inst]int) []int {
+	switch inst := bc.Code[pc]; inst.Opcode {
+	case opcode.Match:
+		return nil
+	case opcode.Jmp:
+		return []int{index[inst.X]}
+	case opcode.Split:
+		return []int{index[inst.X], index[inst.Y]}
+	}
+	if pc+1 < bc.N {
+		return []int{pc + 1}
+	}
+	return nil
+}
+
+// threadJmp makes the Jmp and Split instructions jump to the instruction which
+// in the end will be reached through the NOP and Jmp instructions.
+// (e.g. [00:Jmp 01 -> 01:NOP -> 02:Jmp 03 -> 03:Char 'a'] to [00:Jmp 03 -> ...])
+func (bc *BC) threadJmp() bool {
+	index := bc.index()
+	changed := false
+	thread := func(dst **instruction.Inst) {
+		if d := bc.destination(*dst, index); d != *dst {
+			*dst = d
+			changed = true
+		}
+	}
+	for _, inst := range bc.Code {
+		switch inst.Opcode {
+		case opcode.Jmp:
+			thread(&inst.X)
+		case opcode.Split:
+			thread(&inst.X)
+			thread(&inst.Y)
+		}
+	}
+	return changed
+}
+
+// destination returns the instruction which will be reached from the argument instruction
+// through the NOP and Jmp instructions. The loop of them ends at the first instruction
+// visited twice.
+func (bc *BC) destination(inst *instruction.Inst, index map[*instruction.Inst]int) *instruction.Inst {
+	seen := map[*instruction.Inst]bool{}
+	for !seen[inst] {
+		seen[inst] = true
+		switch inst.Opcode {
+		case opcode.NOP:
+			pc := index[inst]
+			if pc+1 >= bc.N {
+				return inst
+			}
+			inst = bc.Code[pc+1]
+		case opcode.Jmp:
+			inst = inst.X
+		default:
+			return inst
+		}
+	}
+	return inst
+}
+
+// collapseSplit replaces the Split instructions whose both branches are the same
+// with the Jmp instructions. (e.g. [00:Split 01, 01] to [00:Jmp 01])
+func (bc *BC) collapseSplit() bool {
+	changed := false
+	for _, inst := range bc.Code {
+		if inst.Opcode == opcode.Split && inst.X == inst.Y {
+			inst.Opcode = opcode.Jmp
+			inst.Y = nil
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeDeadCode removes the instructions which can't be reached from the top of the bytecode.
+func (bc *BC) removeDeadCode() bool {
+	if bc.N == 0 {
+		return false
+	}
+	index := bc.index()
+	reached := make([]bool, bc.N)
+	stack := []int{0}
+	reached[0] = true
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range bc.successors(pc, index) {
+			if !reached[next] {
+				reached[next] = true
+				stack = append(stack, next)
+			}
+		}
+	}
+
+	newBC := NewByteCode()
+	for pc, inst := range bc.Code {
+		if reached[pc] {
+			newBC.AddInst(inst, newBC.N)
+		}
+	}
+	changed := newBC.N != bc.N
+	bc.Code = newBC.Code
+	bc.N = newBC.N
+	return changed
+}
+
+// removeNOP removes NOP instructions from BC to minimize it. The Jmp instructions to
+// the next instruction are removed as well, because they are the same as NOP.
+// The jumps to the removed instructions are redirected to the next instructions.
+// The NOP instructions at the bottom are kept if other instructions jump to them.
+func (bc *BC) removeNOP() bool {
+	// dst[pc] is the position of the instruction which takes the place of the one at pc.
+	dst := make([]int, bc.N)
+	index := bc.index()
+	for pc := bc.N - 1; pc >= 0; pc-- {
+		inst := bc.Code[pc]
+		dst[pc] = pc
+		if inst.Opcode == opcode.Jmp && pc+1 < bc.N && index[inst.X] > pc && dst[index[inst.X]] == dst[pc+1] {
+			inst.Opcode = opcode.NOP
+			inst.X = nil
+		}
+		if inst.Opcode == opcode.NOP && pc+1 < bc.N {
+			dst[pc] = dst[pc+1]
+		}
+	}
+
+	for _, inst := range bc.Code {
+		switch inst.Opcode {
+		case opcode.Jmp:
+			inst.X = bc.Code[dst[index[inst.X]]]
+		case opcode.Split:
+			inst.X = bc.Code[dst[index[inst.X]]]
+			inst.Y = bc.Code[dst[index[inst.Y]]]
+		}
+	}
+
+	newBC := NewByteCode()
+	for pc, inst := range bc.Code {
+		if dst[pc] == pc {
+			newBC.AddInst(inst, newBC.N)
+		}
+	}
+	changed := newBC.N != bc.N
+	bc.Code = newBC.Code
+	bc.N = newBC.N
+	return changed
+}
+
+// coalesceChar coalesces the consecutive Char instructions into a String instruction,
+// so that VM matches the runes at once. (e.g. [00:Char 'a' -> 01:Char 'b' -> 02:Char 'c'] to [00:String "abc"])
+// The Char instructions which other instructions jump to are not coalesced with the former ones.
+func (bc *BC) coalesceChar() bool {
+	targets := map[*instruction.Inst]bool{}
+	for _, inst := range bc.Code {
+		if inst.X != nil {
+			targets[inst.X] = true
+		}
+		if inst.Y != nil {
+			targets[inst.Y] = true
+		}
+	}
+
+	newBC := NewByteCode()
+	for i := 0; i < bc.N; i++ {
+		inst := bc.Code[i]
+		newBC.AddInst(inst, newBC.N)
+		if inst.Opcode != opcode.Char {
+			continue
+		}
+
+		s := []rune{inst.C}
+		for i+1 < bc.N && bc.Code[i+1].Opcode == opcode.Char && !targets[bc.Code[i+1]] {
+			i++
+			s = append(s, bc.Code[i].C)
+		}
+		if len(s) > 1 {
+			// Keep the first instruction, which may be jumped to.
+			inst.Opcode = opcode.String
+			inst.S = s
+		}
+	}
+	changed := newBC.N != bc.N
+	bc.Code = newBC.Code
+	bc.N = newBC.N
+	return changed
+}
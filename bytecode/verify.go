@@ -0,0 +1,132 @@
+package bytecode
+
+import (
+	"fmt"
+
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// MaxSlots is the maximum number of the capture slots which the bytecode can use,
+// so that the executors never allocate the huge slots for a broken bytecode.
+// (e.g. Save 2000000000)
+const MaxSlots = 1 << 16
+
+// VerifyError represents a problem of the bytecode found by Verify.
+type VerifyError struct {
+	Index   int    // position of the instruction, or -1 if the problem is of the whole bytecode
+	Problem string // description of the problem
+}
+
+func (e *VerifyError) Error() string {
+	if e.Index < 0 {
+		return "bytecode: " + e.Problem
+	}
+	return fmt.Sprintf("bytecode: %02d: %s", e.Index, e.Problem)
+}
+
+// Verify checks whether the argument bytecode can be executed safely, that is,
+// every instruction is well-formed, every jump target exists in the bytecode,
+// every Save instruction records the position in one of MaxSlots capture slots,
+// no instruction falls through the bottom, and Match exists.
+// It returns a *VerifyError describing the first problem found, or nil.
+func Verify(bc *BC) error {
+	if bc.N != len(bc.Code) {
+		return &VerifyError{-1, fmt.Sprintf("size %d differs from the number of the instructions %d", bc.N, len(bc.Code))}
+	}
+	if bc.N == 0 {
+		return &VerifyError{-1, "no instruction"}
+	}
+
+	index := map[*instruction.Inst]int{}
+	hasMatch := false
+	for i, inst := range bc.Code {
+		if inst == nil {
+			return &VerifyError{i, "nil instruction"}
+		}
+		if j, ok := index[inst]; ok {
+			return &VerifyError{i, fmt.Sprintf("same instruction as %02d", j)}
+		}
+		index[inst] = i
+
+		switch inst.Opcode {
+		case opcode.Char, opcode.ANY, opcode.Class, opcode.Begin, opcode.End, opcode.Progress, opcode.NOP:
+		case opcode.Match:
+			hasMatch = true
+		case opcode.Jmp, opcode.Split:
+			// The targets are checked below, after all the instructions are indexed.
+		case opcode.String:
+			if len(inst.S) == 0 {
+				return &VerifyError{i, "String with no rune"}
+			}
+		case opcode.Save:
+			if inst.N < 0 {
+				return &VerifyError{i, fmt.Sprintf("Save to negative slot %d", inst.N)}
+			}
+			if inst.N >= MaxSlots {
+				return &VerifyError{i, fmt.Sprintf("Save to slot %d beyond MaxSlots %d", inst.N, MaxSlots)}
+			}
+		default:
+			return &VerifyError{i, fmt.Sprintf("unknown opcode %d", inst.Opcode)}
+		}
+	}
+	if err := bc.verifyJumps(index); err != nil {
+		return err
+	}
+
+	switch last := bc.Code[bc.N-1]; last.Opcode {
+	case opcode.Match, opcode.Jmp, opcode.Split:
+	default:
+		return &VerifyError{bc.N - 1, fmt.Sprintf("%v falls through the bottom", last.Opcode)}
+	}
+	if !hasMatch {
+		return &VerifyError{-1, "no Match instruction"}
+	}
+	return nil
+}
+
+// VerifySlots is the same as Verify, but also checks whether every Save instruction
+// records the position in one of the nslots capture slots (0 to nslots-1), so that the
+// executors can allocate the slots before running the bytecode.
+func VerifySlots(bc *BC, nslots int) error {
+	if err := Verify(bc); err != nil {
+		return err
+	}
+	for i, inst := range bc.Code {
+		if inst.Opcode == opcode.Save && inst.N >= nslots {
+			return &VerifyError{i, fmt.Sprintf("Save to slot %d out of %d slots", inst.N, nslots)}
+		}
+	}
+	return nil
+}
+
+// verifyJumps returns an error if a Jmp or Split instruction jumps to the instruction
+// which doesn't exist in the bytecode. The argument index has the position of
+// each instruction in the bytecode.
+func (bc *BC) verifyJumps(index map[*instruction.Inst]int) error {
+	target := func(i int, name string, x *instruction.Inst) error {
+		if x == nil {
+			return &VerifyError{i, fmt.Sprintf("%s with nil target", name)}
+		}
+		if _, ok := index[x]; !ok {
+			return &VerifyError{i, fmt.Sprintf("%s to the instruction not in the bytecode", name)}
+		}
+		return nil
+	}
+	for i, inst := range bc.Code {
+		switch inst.Opcode {
+		case opcode.Jmp:
+			if err := target(i, "Jmp", inst.X); err != nil {
+				return err
+			}
+		case opcode.Split:
+			if err := target(i, "Split (X)", inst.X); err != nil {
+				return err
+			}
+			if err := target(i, "Split (Y)", inst.Y); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
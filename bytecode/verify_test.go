@@ -0,0 +1,62 @@
+package bytecode
+
+import (
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+func TestVerify(t *testing.T) {
+	m := instruction.NewInst(opcode.Match, 0, nil, nil)
+	outside := instruction.NewInst(opcode.Match, 0, nil, nil)
+	tests := []struct {
+		code []*instruction.Inst
+		ok   bool
+	}{
+		{[]*instruction.Inst{m}, true},
+		{[]*instruction.Inst{instruction.NewInst(opcode.Jmp, 0, m, nil), m}, true},
+		{[]*instruction.Inst{instruction.NewSaveInst(2), instruction.NewSaveInst(MaxSlots - 1), m}, true},
+		{nil, false},
+		{[]*instruction.Inst{nil, m}, false},
+		{[]*instruction.Inst{m, m}, false},
+		{[]*instruction.Inst{instruction.NewInst(opcode.Jmp, 0, nil, nil), m}, false},
+		{[]*instruction.Inst{instruction.NewInst(opcode.Jmp, 0, outside, nil), m}, false},
+		{[]*instruction.Inst{instruction.NewInst(opcode.Char, 'a', nil, nil)}, false},
+		{[]*instruction.Inst{m, instruction.NewInst(opcode.Char, 'a', nil, nil)}, false},
+		{[]*instruction.Inst{instruction.NewSaveInst(-1), m}, false},
+		{[]*instruction.Inst{instruction.NewSaveInst(MaxSlots), m}, false},
+		{[]*instruction.Inst{instruction.NewSaveInst(2000000000), m}, false},
+		{[]*instruction.Inst{{Opcode: opcode.String}, m}, false},
+		{[]*instruction.Inst{{Opcode: opcode.NOP + 1}, m}, false},
+	}
+	for i, tt := range tests {
+		if err := Verify(&BC{N: len(tt.code), Code: tt.code}); (err == nil) != tt.ok {
+			t.Errorf("%d: Verify = %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestVerifySlots(t *testing.T) {
+	tests := []struct {
+		slots  []int
+		nslots int
+		ok     bool
+	}{
+		{nil, 2, true},
+		{[]int{2, 3}, 4, true},
+		{[]int{2, 3}, 3, false},
+		{[]int{2, MaxSlots}, MaxSlots + 1, false},
+		{[]int{-1}, 4, false},
+	}
+	for i, tt := range tests {
+		bc := NewByteCode()
+		for _, n := range tt.slots {
+			bc.AddInst(instruction.NewSaveInst(n), bc.N)
+		}
+		bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+		if err := VerifySlots(bc, tt.nslots); (err == nil) != tt.ok {
+			t.Errorf("%d: VerifySlots = %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
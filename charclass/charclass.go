@@ -0,0 +1,181 @@
+// Package charclass provides the character class structure, a set of runes
+// represented as sorted ranges, and some utilities to build it.
+package charclass
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+)
+
+// Range represents the closed interval of runes [Lo, Hi].
+type Range struct {
+	Lo rune
+	Hi rune
+}
+
+// Class represents a set of runes.
+// The ranges are always sorted and never overlap (or adjoin) each other,
+// so that we can find the range containing a rune by a binary search.
+type Class []Range
+
+// New returns a new Class which consists of the argument ranges.
+func New(rs ...Range) Class {
+	c := make(Class, len(rs))
+	copy(c, rs)
+	return c.canonicalize()
+}
+
+// FromTable returns a new Class which consists of the runes in the argument range table.
+func FromTable(t *unicode.RangeTable) Class {
+	c := Class{}
+	for _, r := range t.R16 {
+		c = appendStride(c, rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range t.R32 {
+		c = appendStride(c, rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	return c.canonicalize()
+}
+
+// appendStride appends the runes lo, lo+stride, lo+2*stride, ... hi to c.
+func appendStride(c Class, lo, hi, stride rune) Class {
+	if stride == 1 {
+		return append(c, Range{lo, hi})
+	}
+	for r := lo; r <= hi; r += stride {
+		c = append(c, Range{r, r})
+	}
+	return c
+}
+
+func (c Class) String() string {
+	s := ""
+	for _, r := range c {
+		if r.Lo == r.Hi {
+			s += fmt.Sprintf("%q", r.Lo)
+		} else {
+			s += fmt.Sprintf("%q-%q", r.Lo, r.Hi)
+		}
+	}
+	return "[" + s + "]"
+}
+
+// Contains returns whether the rune r is in the Class.
+func (c Class) Contains(r rune) bool {
+	i := sort.Search(len(c), func(i int) bool {
+		return c[i].Hi >= r
+	})
+	return i < len(c) && c[i].Lo <= r
+}
+
+// Equal returns whether c and d contain the same runes.
+func (c Class) Equal(d Class) bool {
+	if len(c) != len(d) {
+		return false
+	}
+	for i := range c {
+		if c[i] != d[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns a new Class which contains the runes in c or d.
+func (c Class) Union(d Class) Class {
+	u := make(Class, 0, len(c)+len(d))
+	u = append(u, c...)
+	u = append(u, d...)
+	return u.canonicalize()
+}
+
+// Intersect returns a new Class which contains the runes in both c and d.
+func (c Class) Intersect(d Class) Class {
+	n := Class{}
+	for i, j := 0, 0; i < len(c) && j < len(d); {
+		lo, hi := c[i].Lo, c[i].Hi
+		if d[j].Lo > lo {
+			lo = d[j].Lo
+		}
+		if d[j].Hi < hi {
+			hi = d[j].Hi
+		}
+		if lo <= hi {
+			n = append(n, Range{lo, hi})
+		}
+		if c[i].Hi < d[j].Hi {
+			i++
+		} else {
+			j++
+		}
+	}
+	return n
+}
+
+// Difference returns a new Class which contains the runes in c but NOT in d.
+func (c Class) Difference(d Class) Class {
+	return c.Intersect(d.Negate())
+}
+
+// Negate returns a new Class which contains every rune NOT in c.
+func (c Class) Negate() Class {
+	n := Class{}
+	next := rune(0)
+	for _, r := range c {
+		if r.Lo > next {
+			n = append(n, Range{next, r.Lo - 1})
+		}
+		next = r.Hi + 1
+	}
+	if next <= unicode.MaxRune {
+		n = append(n, Range{next, unicode.MaxRune})
+	}
+	return n
+}
+
+// The range of runes which have other runes in their case folding orbit.
+const (
+	minFold = 0x0041
+	maxFold = 0x1e943
+)
+
+// Fold returns a new Class which is closed under the simple case folding,
+// i.e. contains also the other cases of all the runes in c. (e.g. [a-c] to [A-Ca-c])
+func (c Class) Fold() Class {
+	f := make(Class, len(c))
+	copy(f, c)
+	for _, r := range c {
+		lo, hi := r.Lo, r.Hi
+		if lo < minFold {
+			lo = minFold
+		}
+		if hi > maxFold {
+			hi = maxFold
+		}
+		for x := lo; x <= hi; x++ {
+			for y := unicode.SimpleFold(x); y != x; y = unicode.SimpleFold(y) {
+				f = append(f, Range{y, y})
+			}
+		}
+	}
+	return f.canonicalize()
+}
+
+// canonicalize sorts the ranges and merges the overlapping (or adjoining) ones.
+func (c Class) canonicalize() Class {
+	sort.Slice(c, func(i, j int) bool {
+		return c[i].Lo < c[j].Lo
+	})
+	n := Class{}
+	for _, r := range c {
+		if len(n) > 0 && r.Lo <= n[len(n)-1].Hi+1 {
+			if r.Hi > n[len(n)-1].Hi {
+				n[len(n)-1].Hi = r.Hi
+			}
+			continue
+		}
+		n = append(n, r)
+	}
+	return n
+}
@@ -0,0 +1,396 @@
+// Package codegen generates the Go source code of the matcher of a compiled regular expression,
+// so that the regular expression can be compiled ahead of time instead of at run time.
+//
+// The generated matcher is a backtracking VM specialized for the compiled program: each
+// instruction is translated into the Go code, and the threads run the code in the same order
+// as vm.VM runs the program. So the generated matcher finds the same match as vmregex.Regexp.
+// The generated code depends on no package other than the standard library.
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+	"github.com/8ayac/vm-regex-engine/vmregex"
+)
+
+// visitedPageSize is the number of states (pairs of PC and SP) in a page of the set of
+// the visited states in the generated matcher. It is the same as the one of vm.VM.
+const visitedPageSize = 256 * 1024
+
+// Generate returns the Go source code of the package pkg which has the matcher of
+// the compiled regular expression re as the variable name. The matcher has the methods
+// Match, MatchString, MatchSubmatch, NumSubexp and String, which return the same
+// values as the ones of vmregex.Regexp.
+// The generated matcher has no budget of the matching, so Generate returns an error
+// if re is compiled with Options.MaxSteps or Options.Timeout.
+func Generate(re *vmregex.Regexp, pkg, name string) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("invalid package name: %q", pkg)
+	}
+	if !token.IsIdentifier(name) || name == "_" {
+		return nil, fmt.Errorf("invalid matcher name: %q", name)
+	}
+	opts := re.Options()
+	if opts.MaxSteps > 0 || opts.Timeout > 0 {
+		return nil, fmt.Errorf("MaxSteps and Timeout are not available in the generated code")
+	}
+
+	g := &generator{
+		re:     re,
+		prog:   re.Program(),
+		prefix: strings.ToLower(name[:1]) + name[1:],
+		used:   map[string]bool{},
+	}
+	g.longest = opts.Longest || opts.Dialect == vmregex.DialectPOSIX
+	g.maxThreads = opts.MaxThreads
+	if g.maxThreads == 0 {
+		g.maxThreads = vm.DefaultMaxThreads
+	}
+	g.analyze()
+
+	body := &bytes.Buffer{}
+	g.w = body
+	g.matcher(name)
+	g.search()
+	g.run()
+	g.helpers()
+
+	g.w = &bytes.Buffer{}
+	g.printf("// Code generated by vm-regex-engine gen; DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", pkg)
+	if len(re.RequiredLiterals()) > 0 {
+		g.printf("import \"strings\"\n\n")
+	}
+	g.w.Write(body.Bytes())
+
+	src, err := format.Source(g.w.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code is broken: %v", err)
+	}
+	return src, nil
+}
+
+// generator has the state of the code generation.
+type generator struct {
+	w          *bytes.Buffer
+	re         *vmregex.Regexp
+	prog       *bytecode.BC
+	prefix     string // prefix of the identifiers in the generated code
+	longest    bool
+	maxThreads int
+
+	index    map[*instruction.Inst]int // position of each instruction in the program
+	ncap     int                       // number of the capture slots
+	progress map[int]int               // index of the record for each Progress instruction
+	classes  []charclass.Class         // table of the character classes
+	strings  [][2]string               // names and values of the runes of String instructions
+	used     map[string]bool           // helpers used by the generated code
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.w, format, args...)
+}
+
+// id returns the identifier in the generated code, which is prefixed not to collide
+// with the ones of the other matchers in the same package.
+func (g *generator) id(s string) string {
+	return g.prefix + s
+}
+
+// analyze collects what the generated code needs from the program, as well as vm.NewVM.
+func (g *generator) analyze() {
+	g.ncap = 2
+	g.progress = map[int]int{}
+	g.index = map[*instruction.Inst]int{}
+	for i, inst := range g.prog.Code {
+		g.index[inst] = i
+		switch inst.Opcode {
+		case opcode.Progress:
+			g.progress[i] = len(g.progress)
+		case opcode.Save:
+			if inst.N >= g.ncap {
+				g.ncap = inst.N + 1
+			}
+		case opcode.Class:
+			g.used["Contains"] = true
+		case opcode.String:
+			g.used["HasRunes"] = true
+		case opcode.Begin:
+			if inst.Class != nil {
+				g.used["AtBegin"] = true
+			}
+		case opcode.End:
+			if inst.Class != nil {
+				g.used["AtEnd"] = true
+			}
+		}
+	}
+	if prefix, _ := g.re.LiteralPrefix(); prefix != "" {
+		g.used["HasRunes"] = true
+	}
+}
+
+// class returns the name of the table of the character class in the generated code.
+func (g *generator) class(c charclass.Class) string {
+	for i, t := range g.classes {
+		if t.Equal(c) {
+			return g.id(fmt.Sprintf("Class%d", i))
+		}
+	}
+	g.classes = append(g.classes, c)
+	return g.id(fmt.Sprintf("Class%d", len(g.classes)-1))
+}
+
+// matcher generates the matcher type and its methods.
+func (g *generator) matcher(name string) {
+	t := g.id("Matcher")
+	g.printf("// %s is the matcher of the regular expression %q generated ahead of time.\n", name, g.re.String())
+	g.printf("var %s = %s{}\n\n", name, t)
+	g.printf("// %s is the type of %s.\n", t, name)
+	g.printf("type %s struct{}\n\n", t)
+
+	g.printf("// String returns the source text of the regular expression.\n")
+	g.printf("func (%s) String() string {\nreturn %q\n}\n\n", t, g.re.String())
+	g.printf("// NumSubexp returns the number of the capturing groups in the regular expression.\n")
+	g.printf("func (%s) NumSubexp() int {\nreturn %d\n}\n\n", t, g.re.NumSubexp())
+
+	g.printf("// Match returns the byte offsets of the start and the end of the matched string,\n")
+	g.printf("// or 0, 0 if not matched.\n")
+	g.printf("func (%s) Match(s string) (start, end int) {\n", t)
+	g.printf("if !%s(s) {\nreturn 0, 0\n}\n", g.id("MayMatch"))
+	g.printf("start, end = %s(append([]rune(s), '\\x00'), nil)\n", g.id("Search"))
+	g.printf("if start == -1 {\nreturn 0, 0\n}\n")
+	g.printf("offsets := %s(s)\n", g.id("ByteOffsets"))
+	g.printf("return offsets[start], offsets[end]\n}\n\n")
+
+	g.printf("// MatchString returns whether the input string has a string matching the regular expression.\n")
+	g.printf("func (%s) MatchString(s string) bool {\n", t)
+	g.printf("if !%s(s) {\nreturn false\n}\n", g.id("MayMatch"))
+	g.printf("start, _ := %s(append([]rune(s), '\\x00'), nil)\n", g.id("Search"))
+	g.printf("return start != -1\n}\n\n")
+
+	g.printf("// MatchSubmatch returns the byte offsets of the matched string and the strings\n")
+	g.printf("// matched by the capturing groups (-1 if the group didn't match), or nil if not matched.\n")
+	g.printf("func (%s) MatchSubmatch(s string) []int {\n", t)
+	g.printf("if !%s(s) {\nreturn nil\n}\n", g.id("MayMatch"))
+	g.printf("caps := make([]int, %d)\n", g.ncap)
+	g.printf("start, end := %s(append([]rune(s), '\\x00'), caps)\n", g.id("Search"))
+	g.printf("if start == -1 {\nreturn nil\n}\n")
+	g.printf("caps[0], caps[1] = start, end\n")
+	g.printf("offsets := %s(s)\n", g.id("ByteOffsets"))
+	g.printf("m := make([]int, %d)\n", 2*(g.re.NumSubexp()+1))
+	g.printf("for i := range m {\nm[i] = -1\nif i < len(caps) && caps[i] != -1 {\nm[i] = offsets[caps[i]]\n}\n}\n")
+	g.printf("return m\n}\n\n")
+
+	g.printf("// %s returns whether the input string contains all the literals required to match.\n", g.id("MayMatch"))
+	g.printf("func %s(s string) bool {\n", g.id("MayMatch"))
+	for _, lit := range g.re.RequiredLiterals() {
+		g.printf("if !strings.Contains(s, %q) {\nreturn false\n}\n", lit)
+	}
+	g.printf("return true\n}\n\n")
+
+	g.printf("// %s returns the byte offsets of each rune in the string s, and the length of s.\n", g.id("ByteOffsets"))
+	g.printf("func %s(s string) []int {\n", g.id("ByteOffsets"))
+	g.printf("offsets := make([]int, 0, len(s)+1)\nfor i := range s {\noffsets = append(offsets, i)\n}\n")
+	g.printf("return append(offsets, len(s))\n}\n\n")
+}
+
+// search generates the function which runs the threads from each position of the input,
+// as well as vm.VM.Search.
+func (g *generator) search() {
+	prefix, _ := g.re.LiteralPrefix()
+	if prefix != "" {
+		g.printf("var %s = []rune(%q)\n\n", g.id("Prefix"), prefix)
+	}
+
+	g.printf("// %s returns the positions of the start and the end of the matched string\n", g.id("Search"))
+	g.printf("// in the input runes terminated by '\\x00', or -1, -1 if not matched.\n")
+	g.printf("// If caps is not nil, the capture slots of the matched thread are copied to it.\n")
+	g.printf("func %s(input []rune, caps []int) (int, int) {\n", g.id("Search"))
+	g.printf("n := %d * len(input)\n", g.prog.N+1)
+	g.printf("visited := &%s{pages: make([][]uint64, (n+%d)/%d), size: n}\n", g.id("Visited"), visitedPageSize-1, visitedPageSize)
+	g.printf("for start := 0; start < len(input); start++ {\n")
+	if prefix != "" {
+		g.printf("if !%s(input[start:len(input)-1], %s) {\ncontinue\n}\n", g.id("HasRunes"), g.id("Prefix"))
+	}
+	g.printf("end := %s(input, start, visited, caps)\n", g.id("Run"))
+	g.printf("if end == -2 {\n// The stack of threads overflowed.\nreturn -1, -1\n}\n")
+	g.printf("if end != -1 {\nreturn start, end\n}\n")
+	g.printf("}\nreturn -1, -1\n}\n\n")
+
+	v := g.id("Visited")
+	g.printf("// %s is the set of the visited states, which are stored in the pages allocated\n", v)
+	g.printf("// when the states in them are visited first.\n")
+	g.printf("type %s struct {\npages [][]uint64\nsize int\n}\n\n", v)
+	g.printf("// testAndSet adds the state i to the set, and returns whether it was already in the set.\n")
+	g.printf("func (s *%s) testAndSet(i int) bool {\n", v)
+	g.printf("p := i / %d\npage := s.pages[p]\n", visitedPageSize)
+	g.printf("if page == nil {\nn := s.size - p*%d\nif n > %d {\nn = %d\n}\n", visitedPageSize, visitedPageSize, visitedPageSize)
+	g.printf("page = make([]uint64, (n+63)/64)\ns.pages[p] = page\n}\n")
+	g.printf("i -= p * %d\n", visitedPageSize)
+	g.printf("if page[i/64]&(1<<uint(i%%64)) != 0 {\nreturn true\n}\n")
+	g.printf("page[i/64] |= 1 << uint(i%%64)\nreturn false\n}\n\n")
+}
+
+// run generates the function which runs the threads from a position of the input,
+// as well as vm.VM.Run. Each case of the switch is the code of the instruction at the PC.
+func (g *generator) run() {
+	th := g.id("Thread")
+	g.printf("// %s is a thread waiting to run.\n", th)
+	g.printf("type %s struct {\npc, sp int\nprogress, caps []int\n}\n\n", th)
+
+	g.printf("// %s runs the threads from the position start in the input runes, and returns\n", g.id("Run"))
+	g.printf("// the position next to the matched string, -1 if not matched, or -2 if the stack overflowed.\n")
+	g.printf("func %s(input []rune, start int, visited *%s, caps []int) int {\n", g.id("Run"), g.id("Visited"))
+	g.printf("first := %s{pc: 0, sp: start}\n", th)
+	if len(g.progress) > 0 {
+		g.printf("first.progress = make([]int, %d)\nfor i := range first.progress {\nfirst.progress[i] = -1\n}\n", len(g.progress))
+	}
+	g.printf("if caps != nil {\nfirst.caps = make([]int, len(caps))\nfor i := range first.caps {\nfirst.caps[i] = -1\n}\n}\n")
+	g.printf("stack := []%s{first}\n", th)
+	g.printf("matched := -1\n\n")
+
+	g.printf("for len(stack) > 0 {\n")
+	g.printf("t := stack[len(stack)-1]\nstack = stack[:len(stack)-1]\n")
+	g.printf("pc, sp, captured := t.pc, t.sp, t.caps\n")
+	if len(g.progress) > 0 {
+		g.printf("progress := t.progress\n")
+	}
+	g.printf("thread:\nfor {\n")
+	g.printf("if visited.testAndSet(pc*len(input) + sp) {\nbreak thread\n}\n")
+	g.printf("switch pc {\n")
+	for i, inst := range g.prog.Code {
+		g.printf("case %d: // %v\n", i, inst.Opcode)
+		g.inst(i)
+	}
+	g.printf("}\n}\n}\nreturn matched\n}\n\n")
+}
+
+// inst generates the code of the instruction at pc.
+func (g *generator) inst(pc int) {
+	inst := g.prog.Code[pc]
+	index := func(target *instruction.Inst) int {
+		return g.index[target]
+	}
+	next := fmt.Sprintf("pc = %d\n", pc+1)
+
+	switch inst.Opcode {
+	case opcode.Char:
+		g.printf("if sp == len(input)-1 || input[sp] != %s {\nbreak thread\n}\nsp++\n%s", runeLit(inst.C), next)
+	case opcode.String:
+		name := g.id(fmt.Sprintf("String%d", pc))
+		g.strings = append(g.strings, [2]string{name, runesLit(inst.S)})
+		g.printf("if !%s(input[sp:len(input)-1], %s) {\nbreak thread\n}\nsp += %d\n%s", g.id("HasRunes"), name, len(inst.S), next)
+	case opcode.ANY:
+		g.printf("if sp == len(input)-1 {\nbreak thread\n}\nsp++\n%s", next)
+	case opcode.Class:
+		g.printf("if sp == len(input)-1 || !%s(%s, input[sp]) {\nbreak thread\n}\nsp++\n%s", g.id("Contains"), g.class(inst.Class), next)
+	case opcode.Match:
+		if g.longest {
+			g.printf("if matched == -1 || sp > matched {\nmatched = sp\ncopy(caps, captured)\n}\nbreak thread\n")
+		} else {
+			g.printf("copy(caps, captured)\nreturn sp\n")
+		}
+	case opcode.Jmp:
+		g.printf("pc = %d\n", index(inst.X))
+	case opcode.Split:
+		g.printf("if len(stack) >= %d {\nreturn -2\n}\n", g.maxThreads)
+		progress := "nil"
+		if len(g.progress) > 0 {
+			progress = "append([]int(nil), progress...)"
+		}
+		g.printf("stack = append(stack, %s{pc: %d, sp: sp, progress: %s, caps: append([]int(nil), captured...)})\n",
+			g.id("Thread"), index(inst.Y), progress)
+		g.printf("pc = %d\n", index(inst.X))
+	case opcode.Begin:
+		if inst.Class == nil {
+			g.printf("if sp != 0 {\nbreak thread\n}\n%s", next)
+		} else {
+			g.printf("if !%s(input, sp, %s) {\nbreak thread\n}\n%s", g.id("AtBegin"), g.class(inst.Class), next)
+		}
+	case opcode.End:
+		if inst.Class == nil {
+			g.printf("if sp != len(input)-1 {\nbreak thread\n}\n%s", next)
+		} else {
+			g.printf("if !%s(input, sp, %s) {\nbreak thread\n}\n%s", g.id("AtEnd"), g.class(inst.Class), next)
+		}
+	case opcode.Progress:
+		k := g.progress[pc]
+		g.printf("if progress[%d] == sp {\nbreak thread\n}\nprogress[%d] = sp\n%s", k, k, next)
+	case opcode.Save:
+		g.printf("if captured != nil {\ncaptured[%d] = sp\n}\n%s", inst.N, next)
+	case opcode.NOP:
+		g.printf("%s", next)
+	}
+}
+
+// helpers generates the tables of the character classes and the helper functions
+// used by the generated code.
+func (g *generator) helpers() {
+	for _, s := range g.strings {
+		g.printf("var %s = %s\n\n", s[0], s[1])
+	}
+	for i, c := range g.classes {
+		g.printf("var %s = [][2]rune{", g.id(fmt.Sprintf("Class%d", i)))
+		for _, r := range c {
+			g.printf("{%s, %s}, ", runeLit(r.Lo), runeLit(r.Hi))
+		}
+		g.printf("}\n\n")
+	}
+
+	if g.used["Contains"] || g.used["AtBegin"] || g.used["AtEnd"] {
+		g.printf("// %s returns whether the rune r is in the sorted ranges of the character class.\n", g.id("Contains"))
+		g.printf("func %s(class [][2]rune, r rune) bool {\n", g.id("Contains"))
+		g.printf("lo, hi := 0, len(class)\nfor lo < hi {\nm := (lo + hi) / 2\n")
+		g.printf("switch {\ncase r < class[m][0]:\nhi = m\ncase r > class[m][1]:\nlo = m + 1\ndefault:\nreturn true\n}\n}\n")
+		g.printf("return false\n}\n\n")
+	}
+	if g.used["HasRunes"] {
+		g.printf("// %s returns whether the text starts with the runes lit.\n", g.id("HasRunes"))
+		g.printf("func %s(text, lit []rune) bool {\n", g.id("HasRunes"))
+		g.printf("if len(text) < len(lit) {\nreturn false\n}\n")
+		g.printf("for i, r := range lit {\nif text[i] != r {\nreturn false\n}\n}\nreturn true\n}\n\n")
+	}
+	if g.used["AtBegin"] {
+		g.printf("// %s returns whether the position sp in the input is the beginning of the text or a line.\n", g.id("AtBegin"))
+		g.printf("func %s(input []rune, sp int, lt [][2]rune) bool {\n", g.id("AtBegin"))
+		g.printf("if sp == 0 {\nreturn true\n}\n")
+		g.printf("if !%s(lt, input[sp-1]) {\nreturn false\n}\n", g.id("Contains"))
+		g.printf("return !(input[sp-1] == '\\r' && input[sp] == '\\n' && %s(lt, '\\n'))\n}\n\n", g.id("Contains"))
+	}
+	if g.used["AtEnd"] {
+		g.printf("// %s returns whether the position sp in the input is the end of the text or a line.\n", g.id("AtEnd"))
+		g.printf("func %s(input []rune, sp int, lt [][2]rune) bool {\n", g.id("AtEnd"))
+		g.printf("if sp == len(input)-1 {\nreturn true\n}\n")
+		g.printf("if !%s(lt, input[sp]) {\nreturn false\n}\n", g.id("Contains"))
+		g.printf("return !(input[sp] == '\\n' && sp > 0 && input[sp-1] == '\\r' && %s(lt, '\\r'))\n}\n\n", g.id("Contains"))
+	}
+}
+
+// runeLit returns the Go literal of the rune r. The invalid runes are written as integers,
+// because they can't be quoted.
+func runeLit(r rune) string {
+	if utf8.ValidRune(r) {
+		return fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("%#x", r)
+}
+
+// runesLit returns the Go literal of the runes.
+func runesLit(rs []rune) string {
+	lits := make([]string, len(rs))
+	for i, r := range rs {
+		lits[i] = runeLit(r)
+	}
+	return "[]rune{" + strings.Join(lits, ", ") + "}"
+}
@@ -0,0 +1,144 @@
+package codegen
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/vmregex"
+)
+
+// randomPattern returns a random regular expression nested up to depth.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", "ab", "é", ".", "[a-c]", "[^a]", `\d`, "^", "$", "()", "(?i:b)"}
+	if depth == 0 || rnd.Intn(4) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(5) {
+	case 0, 1:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 2:
+		return "(" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	case 3:
+		return "(?:" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	}
+	quantifiers := []string{"*", "+", "?", "{1,3}"}
+	return "(" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+}
+
+// randomInput returns a random string of the runes which the random patterns use.
+func randomInput(rnd *rand.Rand) string {
+	runes := []rune("abcB1é\n")
+	s := make([]rune, rnd.Intn(10))
+	for i := range s {
+		s[i] = runes[rnd.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+// The generated matchers must return the same values as vmregex.Regexp.
+// The test builds and runs the generated code with the go command.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the generated code in short mode")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	tests := []struct {
+		re   string
+		opts vmregex.Options
+	}{
+		{`(a|b)*c`, vmregex.Options{}},
+		{`abc|abd`, vmregex.Options{}},
+		{`[a-z_]+([0-9]+)?`, vmregex.Options{}},
+		{`^a$`, vmregex.Options{MultiLine: true}},
+		{`(?m)^a|b$`, vmregex.Options{LineTerminator: vmregex.LineTerminatorCRLF}},
+		{`(a|ab)(c|bcd)(d*)`, vmregex.Options{}},
+		{`(a|ab)(c|bcd)(d*)`, vmregex.Options{Longest: true}},
+		{`\p{Greek}+`, vmregex.Options{}},
+		{`[^a]b.`, vmregex.Options{DotAll: true}},
+		{`(?i)héllo`, vmregex.Options{}},
+		{`()*`, vmregex.Options{}},
+		{`((a)|b)+`, vmregex.Options{}},
+		{`x(foo|bar)+y`, vmregex.Options{}},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 150; i++ {
+		opts := vmregex.Options{
+			Longest:         rnd.Intn(4) == 0,
+			CaseInsensitive: rnd.Intn(4) == 0,
+			MultiLine:       rnd.Intn(4) == 0,
+			DotAll:          rnd.Intn(4) == 0,
+		}
+		tests = append(tests, struct {
+			re   string
+			opts vmregex.Options
+		}{randomPattern(rnd, 4), opts})
+	}
+	inputs := []string{"", "a", "abc", "abd", "xfoobary", "ab\r\nb", "αβγ", "HÉLLO", "abcd", "ba\n1"}
+	for i := 0; i < 20; i++ {
+		inputs = append(inputs, randomInput(rnd))
+	}
+
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module gentest\n")
+
+	var res []*vmregex.Regexp
+	main := &strings.Builder{}
+	fmt.Fprintf(main, "package main\n\nimport \"fmt\"\n\n")
+	fmt.Fprintf(main, "var inputs = %#v\n\n", inputs)
+	fmt.Fprintf(main, "func main() {\n")
+	for i, tt := range tests {
+		re, err := vmregex.CompileWithOptions(tt.re, tt.opts)
+		if err != nil {
+			t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+		}
+		res = append(res, re)
+		src, err := Generate(re, "main", fmt.Sprintf("M%d", i))
+		if err != nil {
+			t.Fatalf("Generate(%q): %v", tt.re, err)
+		}
+		write(fmt.Sprintf("m%d.go", i), string(src))
+		fmt.Fprintf(main, "for _, s := range inputs {\n")
+		fmt.Fprintf(main, "start, end := M%d.Match(s)\n", i)
+		fmt.Fprintf(main, "fmt.Println(start, end, M%d.MatchString(s), M%d.MatchSubmatch(s))\n", i, i)
+		fmt.Fprintf(main, "}\n")
+	}
+	fmt.Fprintf(main, "}\n")
+	write("main.go", main.String())
+
+	cmd := exec.Command(goCmd, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %v\n%s", err, out)
+	}
+
+	lines := strings.Split(string(out), "\n")
+	for i, re := range res {
+		for j, s := range inputs {
+			n := i*len(inputs) + j
+			if n >= len(lines) {
+				t.Fatalf("only %d lines are printed", len(lines))
+			}
+			start, end := re.Match(s)
+			want := fmt.Sprint(start, end, re.MatchString(s), re.MatchSubmatch(s))
+			if lines[n] != want {
+				t.Errorf("%q %+v on %q: generated matcher returns %s, want %s", re.String(), re.Options(), s, lines[n], want)
+			}
+		}
+	}
+}
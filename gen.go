@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/8ayac/vm-regex-engine/codegen"
+	"github.com/8ayac/vm-regex-engine/vmregex"
+)
+
+// gen runs the subcommand which generates the Go source code of the matcher
+// of the regular expression given as the argument.
+//
+//	$ vm-regex-engine gen -pkg lexer -name Ident -o ident_gen.go '[a-z_][a-z0-9_]*'
+func gen(args []string) {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	pkg := fs.String("pkg", "main", "package name of the generated code")
+	name := fs.String("name", "Matcher", "variable name of the generated matcher")
+	out := fs.String("o", "", "output file (standard output if empty)")
+	opts := vmregex.Options{}
+	fs.BoolVar(&opts.CaseInsensitive, "i", false, "case-insensitive (same as (?i))")
+	fs.BoolVar(&opts.MultiLine, "m", false, "multi-line mode (same as (?m))")
+	fs.BoolVar(&opts.DotAll, "s", false, "let '.' match line terminators (same as (?s))")
+	fs.BoolVar(&opts.Longest, "longest", false, "leftmost-longest matching")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s gen [flags] regex\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	re, err := vmregex.CompileWithOptions(fs.Arg(0), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := codegen.Generate(re, *pkg, *name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
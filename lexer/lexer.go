@@ -2,35 +2,127 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/8ayac/vm-regex-engine/charclass"
 	"github.com/8ayac/vm-regex-engine/token"
 )
 
+// Flags is bit flags to change the way of analyzing the regular expression.
+type Flags uint
+
+// Most flags can be also set with the inline flags in the regular expression.
+// (e.g. (?x) for FreeSpacing)
+const (
+	// FreeSpacing ignores the white spaces and the comments from '#' to the end of line
+	// outside the brackets, unless they are escaped.
+	FreeSpacing Flags = 1 << iota
+
+	// DotAll lets '.' match also the line terminators.
+	DotAll
+
+	// MultiLine lets '^' and '$' match at the beginning and the end of each line,
+	// not only at the beginning and the end of the text.
+	MultiLine
+
+	// CRLF adds '\r' to the line terminators, and regards "\r\n" as one line terminator.
+	CRLF
+
+	// UnicodeLineTerminators adds '\v', '\f', '\r', U+0085, U+2028 and U+2029
+	// to the line terminators, and regards "\r\n" as one line terminator.
+	UnicodeLineTerminators
+
+	// CaseInsensitive lets the characters and the classes match also the other cases.
+	CaseInsensitive
+
+	// POSIX restricts the syntax to the POSIX extended regular expression,
+	// i.e. the escape sequences of alphanumerics, \Q...\E and the inline flags
+	// are syntax errors.
+	POSIX
+)
+
+// flagLetters maps the letters of the inline flags to the flags.
+var flagLetters = map[rune]Flags{
+	'x': FreeSpacing,
+	's': DotAll,
+	'm': MultiLine,
+	'i': CaseInsensitive,
+}
+
+// SyntaxError represents a syntax error in the regular expression.
+type SyntaxError struct {
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return "[syntax error] " + e.Msg
+}
+
 // Lexer has a slice of symbols to analyze.
 type Lexer struct {
-	s []rune // string to be analyzed
+	s     []rune  // string to be analyzed
+	flags Flags   // flags now in effect
+	stack []Flags // flags to restore at the end of each group
 }
 
 // NewLexer returns a new Lexer.
 // This constructor create a sequence of symbols from
 // the string given in the argument and hold it.
 func NewLexer(s string) *Lexer {
+	return NewLexerWithFlags(s, 0)
+}
+
+// NewLexerWithFlags returns a new Lexer which analyzes
+// the string with the argument flags.
+func NewLexerWithFlags(s string, flags Flags) *Lexer {
 	return &Lexer{
-		s: []rune(s),
+		s:     []rune(s),
+		flags: flags,
 	}
 }
 
 // Scan returns the token list to which converted from
 // the symbol slice held in Lexer struct.
+// If the symbols have a syntax error, Scan panics with *SyntaxError.
 func (l *Lexer) Scan() (tokenList []*token.Token) {
 	for i := 0; i < len(l.s); i++ {
+		if l.flags&FreeSpacing != 0 {
+			if unicode.IsSpace(l.s[i]) {
+				continue
+			}
+			if l.s[i] == '#' {
+				for i+1 < len(l.s) && l.s[i+1] != '\n' {
+					i++
+				}
+				continue
+			}
+		}
+
 		switch l.s[i] {
 		case '\x00':
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.EOF))
 		case '|':
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.UNION))
 		case '(':
-			tokenList = append(tokenList, token.NewToken(l.s[i], token.LPAREN))
+			if i+1 < len(l.s) && l.s[i+1] == '?' && l.flags&POSIX == 0 {
+				var group bool
+				group, i = l.scanInlineFlags(i)
+				if group {
+					tokenList = append(tokenList, token.NewToken('(', token.LPAREN))
+				}
+				continue
+			}
+			l.stack = append(l.stack, l.flags)
+			tokenList = append(tokenList, token.NewGroupToken())
 		case ')':
+			if len(l.stack) > 0 {
+				l.flags = l.stack[len(l.stack)-1]
+				l.stack = l.stack[:len(l.stack)-1]
+			}
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.RPAREN))
 		case '*':
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.STAR))
@@ -38,14 +130,504 @@ func (l *Lexer) Scan() (tokenList []*token.Token) {
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.PLUS))
 		case '?':
 			tokenList = append(tokenList, token.NewToken(l.s[i], token.QUESTION))
+		case '{':
+			if min, max, end, ok := l.scanRepeat(i); ok {
+				t := token.NewToken(l.s[i], token.REPEAT)
+				t.Min, t.Max = min, max
+				tokenList = append(tokenList, t)
+				i = end
+				continue
+			}
+			tokenList = append(tokenList, l.newCharToken(l.s[i]))
 		case '\\':
-			tokenList = append(tokenList, token.NewToken(l.s[i+1], token.CHARACTER))
-			i++
+			if i+1 >= len(l.s) {
+				syntaxError("trailing \x1b[31m\\\x1b[0m")
+			}
+			if l.isClassEscape(l.s[i+1]) {
+				var c charclass.Class
+				c, i = l.scanClassEscape(i + 1)
+				tokenList = append(tokenList, token.NewClassToken(c))
+				continue
+			}
+			if l.s[i+1] == 'Q' && l.flags&POSIX == 0 {
+				var quoted []rune
+				quoted, i = l.scanQuote(i + 2)
+				for _, r := range quoted {
+					tokenList = append(tokenList, l.newCharToken(r))
+				}
+				continue
+			}
+			if l.s[i+1] == 'E' && l.flags&POSIX == 0 {
+				// \E without \Q is meaningless, so simply ignore it.
+				i++
+				continue
+			}
+			var r rune
+			r, i = l.scanEscape(i + 1)
+			tokenList = append(tokenList, l.newCharToken(r))
+		case '[':
+			var c charclass.Class
+			c, i = l.scanBracket(i)
+			tokenList = append(tokenList, token.NewClassToken(c))
 		case '.':
-			tokenList = append(tokenList, token.NewToken(l.s[i], token.ANY))
+			if l.flags&DotAll != 0 {
+				tokenList = append(tokenList, token.NewToken(l.s[i], token.ANY))
+			} else {
+				tokenList = append(tokenList, token.NewClassToken(l.lineTerminators().Negate()))
+			}
+		case '^':
+			tokenList = append(tokenList, l.newAnchorToken(token.BEGIN))
+		case '$':
+			tokenList = append(tokenList, l.newAnchorToken(token.END))
 		default:
-			tokenList = append(tokenList, token.NewToken(l.s[i], token.CHARACTER))
+			tokenList = append(tokenList, l.newCharToken(l.s[i]))
 		}
 	}
 	return
 }
+
+// newCharToken returns a new Token of the character r.
+// In the case-insensitive mode, it returns a Token of CLASS
+// which has all the cases of r instead.
+func (l *Lexer) newCharToken(r rune) *token.Token {
+	if l.flags&CaseInsensitive != 0 && unicode.SimpleFold(r) != r {
+		return token.NewClassToken(charclass.New(charclass.Range{Lo: r, Hi: r}).Fold())
+	}
+	return token.NewToken(r, token.CHARACTER)
+}
+
+// fold returns the class c extended to have all the cases in the case-insensitive mode.
+// The class must be folded before it is negated, otherwise the negated class would have
+// the other cases of the characters excluded from it. (e.g. (?i)[^a] must not match "a")
+func (l *Lexer) fold(c charclass.Class) charclass.Class {
+	if l.flags&CaseInsensitive != 0 {
+		return c.Fold()
+	}
+	return c
+}
+
+// scanRepeat scans the counted repetition ({n}, {n,} or {n,m}) which starts at l.s[i],
+// and returns its minimum and maximum count (-1 for unlimited), and the position of
+// the closing '}'. If l.s[i] is not the beginning of a counted repetition, ok is false
+// and '{' should be regarded as a character.
+func (l *Lexer) scanRepeat(i int) (min, max, end int, ok bool) {
+	end = i + 1
+	for end < len(l.s) && l.s[end] != '}' {
+		end++
+	}
+	if end >= len(l.s) {
+		return 0, 0, 0, false
+	}
+
+	counts := strings.SplitN(string(l.s[i+1:end]), ",", 2)
+	min, err := strconv.Atoi(counts[0])
+	if err != nil || min < 0 {
+		return 0, 0, 0, false
+	}
+	max = min
+	if len(counts) == 2 {
+		max = -1
+		if counts[1] != "" {
+			max, err = strconv.Atoi(counts[1])
+			if err != nil || max < 0 {
+				return 0, 0, 0, false
+			}
+		}
+	}
+	if max != -1 && max < min {
+		syntaxError("invalid repeat count \x1b[31m%s\x1b[0m", string(l.s[i:end+1]))
+	}
+	return min, max, end, true
+}
+
+// lineTerminators returns the character class of the line terminators
+// in the flags now in effect.
+func (l *Lexer) lineTerminators() charclass.Class {
+	switch {
+	case l.flags&UnicodeLineTerminators != 0:
+		return charclass.New(
+			charclass.Range{Lo: '\n', Hi: '\r'},
+			charclass.Range{Lo: '\u0085', Hi: '\u0085'},
+			charclass.Range{Lo: '\u2028', Hi: '\u2029'},
+		)
+	case l.flags&CRLF != 0:
+		return charclass.New(charclass.Range{Lo: '\n', Hi: '\n'}, charclass.Range{Lo: '\r', Hi: '\r'})
+	default:
+		return charclass.New(charclass.Range{Lo: '\n', Hi: '\n'})
+	}
+}
+
+// newAnchorToken returns a new Token of the anchor (BEGIN or END).
+// In the multi-line mode, the token has the line terminators as its class,
+// otherwise it has no class because it matches only at the edge of the text.
+func (l *Lexer) newAnchorToken(k token.Type) *token.Token {
+	t := token.NewToken(0, k)
+	if l.flags&MultiLine != 0 {
+		t.Class = l.lineTerminators()
+	}
+	return t
+}
+
+// scanInlineFlags scans the construct which starts with "(?" at l.s[i], that is
+// an inline comment (?#...), inline flags (?x-x) which change the flags until
+// the end of the enclosing group, or a group with flags (?x-x:...).
+// It returns whether the construct opens a group and the position of its last rune.
+func (l *Lexer) scanInlineFlags(i int) (bool, int) {
+	i += 2
+	if i < len(l.s) && l.s[i] == '#' {
+		for i < len(l.s) && l.s[i] != ')' {
+			i++
+		}
+		if i >= len(l.s) {
+			syntaxError("missing closing \x1b[31m)\x1b[0m")
+		}
+		return false, i
+	}
+
+	flags := l.flags
+	negate := false
+	for ; i < len(l.s); i++ {
+		switch l.s[i] {
+		case '-':
+			if negate {
+				syntaxError("invalid inline flags")
+			}
+			negate = true
+		case ')':
+			l.flags = flags
+			return false, i
+		case ':':
+			l.stack = append(l.stack, l.flags)
+			l.flags = flags
+			return true, i
+		default:
+			f, ok := flagLetters[l.s[i]]
+			if !ok {
+				syntaxError("unknown inline flag \x1b[31m%c\x1b[0m", l.s[i])
+			}
+			if negate {
+				flags &^= f
+			} else {
+				flags |= f
+			}
+		}
+	}
+	syntaxError("missing closing \x1b[31m)\x1b[0m")
+	return false, i
+}
+
+// scanQuote scans the literal span (e.g. \Q*.*\E) whose first quoted rune
+// is at l.s[i], and returns the quoted runes and the position of the last rune of '\E'.
+// If there is no '\E', the span continues to the end of the string.
+func (l *Lexer) scanQuote(i int) ([]rune, int) {
+	for end := i; end+1 < len(l.s); end++ {
+		if l.s[end] == '\\' && l.s[end+1] == 'E' {
+			return l.s[i:end], end + 1
+		}
+	}
+	return l.s[i:], len(l.s) - 1
+}
+
+// scanBracket scans the bracket expression (e.g. [a-z], [^0-9], [\p{L}--\p{Latin}])
+// which starts at l.s[i], and returns its character class and the position
+// of the closing ']'.
+//
+// The set operations in the brackets are intersection '&&' and difference '--',
+// which are evaluated from left to right. (e.g. [\w&&[^\d]] = [A-Z_a-z])
+func (l *Lexer) scanBracket(i int) (charclass.Class, int) {
+	i++
+	negate := false
+	if i < len(l.s) && l.s[i] == '^' {
+		negate = true
+		i++
+	}
+
+	c, i := l.scanBracketUnion(i, true)
+	c = l.fold(c)
+	for l.s[i] != ']' {
+		op := l.s[i]
+		var d charclass.Class
+		d, i = l.scanBracketUnion(i+2, false)
+		d = l.fold(d)
+		if op == '&' {
+			c = c.Intersect(d)
+		} else {
+			c = c.Difference(d)
+		}
+	}
+
+	if negate {
+		c = c.Negate()
+	}
+	return c, i
+}
+
+// scanBracketUnion scans the items in the bracket expression which starts at l.s[i],
+// until the closing ']' or a set operator, and returns the union of them and
+// the position of the closing ']' or the set operator.
+// If top is true, the ']' at l.s[i] is regarded as a character.
+func (l *Lexer) scanBracketUnion(i int, top bool) (charclass.Class, int) {
+	c := charclass.Class{}
+	for first := true; ; first = false {
+		if i >= len(l.s) {
+			syntaxError("missing closing \x1b[31m]\x1b[0m")
+		}
+		if l.s[i] == ']' && !(first && top) {
+			break
+		}
+		if l.isSetOperator(i) && !first {
+			break
+		}
+		var item charclass.Class
+		item, i = l.scanBracketItem(i)
+		c = c.Union(item)
+		i++
+	}
+	return c, i
+}
+
+// isSetOperator returns whether the set operator in the brackets ('&&' or '--')
+// is at l.s[i].
+func (l *Lexer) isSetOperator(i int) bool {
+	return i+1 < len(l.s) && (l.s[i] == '&' || l.s[i] == '-') && l.s[i+1] == l.s[i]
+}
+
+// scanBracketItem scans an item in the bracket expression (a character,
+// a range of characters, an escaped class, a POSIX class, or a nested bracket
+// expression) which starts at l.s[i], and returns its character class and
+// the position of its last rune.
+func (l *Lexer) scanBracketItem(i int) (charclass.Class, int) {
+	if l.s[i] == '[' && i+1 < len(l.s) && l.s[i+1] == ':' {
+		return l.scanPOSIXClass(i)
+	}
+	if l.s[i] == '[' {
+		return l.scanBracket(i)
+	}
+	if l.s[i] == '\\' && i+1 < len(l.s) && l.isClassEscape(l.s[i+1]) {
+		return l.scanClassEscape(i + 1)
+	}
+
+	lo, i := l.scanBracketRune(i)
+	if i+2 < len(l.s) && l.s[i+1] == '-' && l.s[i+2] != ']' && !l.isSetOperator(i+1) {
+		var hi rune
+		hi, i = l.scanBracketRune(i + 2)
+		if hi < lo {
+			syntaxError("invalid range \x1b[31m%c-%c\x1b[0m", lo, hi)
+		}
+		return charclass.New(charclass.Range{Lo: lo, Hi: hi}), i
+	}
+	return charclass.New(charclass.Range{Lo: lo, Hi: lo}), i
+}
+
+// scanBracketRune scans a (possibly escaped) character in the bracket expression
+// which starts at l.s[i], and returns it and the position of its last rune.
+func (l *Lexer) scanBracketRune(i int) (rune, int) {
+	if l.s[i] == '\\' {
+		if i+1 >= len(l.s) {
+			syntaxError("trailing \x1b[31m\\\x1b[0m")
+		}
+		return l.scanEscape(i + 1)
+	}
+	return l.s[i], i
+}
+
+// controlEscapes maps the letters of the control character escapes to the characters.
+var controlEscapes = map[rune]rune{
+	'a': '\a',
+	'e': '\x1b',
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'v': '\v',
+}
+
+// scanEscape scans the escape sequence of a character (e.g. \n, \x41, \u{1F600}, \101, \*)
+// whose first rune after '\' is at l.s[i], and returns the character and the position of its last rune.
+// The escape sequence of an unknown alphabet (e.g. \q) is a syntax error.
+func (l *Lexer) scanEscape(i int) (rune, int) {
+	r := l.s[i]
+	if l.flags&POSIX != 0 {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			syntaxError("unknown escape sequence \x1b[31m\\%c\x1b[0m", r)
+		}
+		return r, i
+	}
+	if c, ok := controlEscapes[r]; ok {
+		return c, i
+	}
+
+	switch {
+	case r == 'x' && i+1 < len(l.s) && l.s[i+1] == '{':
+		return l.scanBracedCodePoint(i + 1)
+	case r == 'x':
+		if i+2 >= len(l.s) {
+			syntaxError("invalid escape sequence \x1b[31m\\%s\x1b[0m", string(l.s[i:]))
+		}
+		return parseCodePoint(string(l.s[i+1:i+3]), 16), i + 2
+	case r == 'u' && i+1 < len(l.s) && l.s[i+1] == '{':
+		return l.scanBracedCodePoint(i + 1)
+	case '0' <= r && r <= '7':
+		end := i + 1
+		for end < len(l.s) && end < i+3 && '0' <= l.s[end] && l.s[end] <= '7' {
+			end++
+		}
+		return parseCodePoint(string(l.s[i:end]), 8), end - 1
+	case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+		syntaxError("unknown escape sequence \x1b[31m\\%c\x1b[0m", r)
+	}
+	return r, i
+}
+
+// scanBracedCodePoint scans the hexadecimal code point in braces (e.g. {1F600})
+// which starts at l.s[i], and returns the character and the position of the closing '}'.
+func (l *Lexer) scanBracedCodePoint(i int) (rune, int) {
+	end := i
+	for end < len(l.s) && l.s[end] != '}' {
+		end++
+	}
+	if end >= len(l.s) {
+		syntaxError("missing closing \x1b[31m}\x1b[0m")
+	}
+	return parseCodePoint(string(l.s[i+1:end]), 16), end
+}
+
+// parseCodePoint returns the character whose code point is represented
+// by the string s in the argument base.
+func parseCodePoint(s string, base int) rune {
+	n, err := strconv.ParseUint(s, base, 32)
+	if err != nil || n > unicode.MaxRune {
+		syntaxError("invalid code point \x1b[31m%s\x1b[0m", s)
+	}
+	return rune(n)
+}
+
+// scanPOSIXClass scans the POSIX class (e.g. [:alpha:], [:^digit:]) which
+// starts at l.s[i], and returns its character class and the position of its last rune.
+func (l *Lexer) scanPOSIXClass(i int) (charclass.Class, int) {
+	end := i + 2
+	for end+1 < len(l.s) && !(l.s[end] == ':' && l.s[end+1] == ']') {
+		end++
+	}
+	if end+1 >= len(l.s) {
+		syntaxError("missing closing \x1b[31m:]\x1b[0m")
+	}
+
+	name := string(l.s[i+2 : end])
+	negate := strings.HasPrefix(name, "^")
+	if negate {
+		name = name[1:]
+	}
+
+	t, ok := posixClasses[name]
+	if !ok {
+		syntaxError("unknown POSIX class \x1b[31m%s\x1b[0m", name)
+	}
+	c := l.fold(charclass.FromTable(t))
+	if negate {
+		c = c.Negate()
+	}
+	return c, end + 1
+}
+
+// posixClasses maps the names of POSIX classes to their ASCII range tables.
+var posixClasses = map[string]*unicode.RangeTable{
+	"alnum":  {R16: []unicode.Range16{{Lo: '0', Hi: '9', Stride: 1}, {Lo: 'A', Hi: 'Z', Stride: 1}, {Lo: 'a', Hi: 'z', Stride: 1}}},
+	"alpha":  {R16: []unicode.Range16{{Lo: 'A', Hi: 'Z', Stride: 1}, {Lo: 'a', Hi: 'z', Stride: 1}}},
+	"ascii":  {R16: []unicode.Range16{{Lo: 0x00, Hi: 0x7f, Stride: 1}}},
+	"blank":  {R16: []unicode.Range16{{Lo: '\t', Hi: '\t', Stride: 1}, {Lo: ' ', Hi: ' ', Stride: 1}}},
+	"cntrl":  {R16: []unicode.Range16{{Lo: 0x00, Hi: 0x1f, Stride: 1}, {Lo: 0x7f, Hi: 0x7f, Stride: 1}}},
+	"digit":  {R16: []unicode.Range16{{Lo: '0', Hi: '9', Stride: 1}}},
+	"graph":  {R16: []unicode.Range16{{Lo: '!', Hi: '~', Stride: 1}}},
+	"lower":  {R16: []unicode.Range16{{Lo: 'a', Hi: 'z', Stride: 1}}},
+	"print":  {R16: []unicode.Range16{{Lo: ' ', Hi: '~', Stride: 1}}},
+	"punct":  {R16: []unicode.Range16{{Lo: '!', Hi: '/', Stride: 1}, {Lo: ':', Hi: '@', Stride: 1}, {Lo: '[', Hi: '`', Stride: 1}, {Lo: '{', Hi: '~', Stride: 1}}},
+	"space":  {R16: []unicode.Range16{{Lo: '\t', Hi: '\r', Stride: 1}, {Lo: ' ', Hi: ' ', Stride: 1}}},
+	"upper":  {R16: []unicode.Range16{{Lo: 'A', Hi: 'Z', Stride: 1}}},
+	"word":   {R16: []unicode.Range16{{Lo: '0', Hi: '9', Stride: 1}, {Lo: 'A', Hi: 'Z', Stride: 1}, {Lo: '_', Hi: '_', Stride: 1}, {Lo: 'a', Hi: 'z', Stride: 1}}},
+	"xdigit": {R16: []unicode.Range16{{Lo: '0', Hi: '9', Stride: 1}, {Lo: 'A', Hi: 'F', Stride: 1}, {Lo: 'a', Hi: 'f', Stride: 1}}},
+}
+
+// isClassEscape returns whether the escape sequence '\' + r represents a character class.
+func (l *Lexer) isClassEscape(r rune) bool {
+	return l.flags&POSIX == 0 && strings.ContainsRune("dDwWsSpP", r)
+}
+
+// scanClassEscape scans the escape sequence of the character class (e.g. \d, \W, \p{Greek})
+// whose letter is at l.s[i], and returns its character class and the position of its last rune.
+func (l *Lexer) scanClassEscape(i int) (charclass.Class, int) {
+	var c charclass.Class
+	switch unicode.ToLower(l.s[i]) {
+	case 'p':
+		return l.scanProperty(i)
+	case 'd':
+		c = charclass.FromTable(posixClasses["digit"])
+	case 'w':
+		c = charclass.FromTable(posixClasses["word"])
+	case 's':
+		c = charclass.FromTable(posixClasses["space"])
+	}
+	c = l.fold(c)
+	if unicode.IsUpper(l.s[i]) {
+		c = c.Negate()
+	}
+	return c, i
+}
+
+// scanProperty scans the Unicode property class (e.g. \pL, \p{Greek}, \P{Lu})
+// whose 'p' (or 'P') is at l.s[i], and returns its character class and
+// the position of its last rune.
+func (l *Lexer) scanProperty(i int) (charclass.Class, int) {
+	negate := l.s[i] == 'P'
+	i++
+	if i >= len(l.s) {
+		syntaxError("missing Unicode property name")
+	}
+
+	name := string(l.s[i])
+	if l.s[i] == '{' {
+		end := i
+		for end < len(l.s) && l.s[end] != '}' {
+			end++
+		}
+		if end >= len(l.s) {
+			syntaxError("missing closing \x1b[31m}\x1b[0m")
+		}
+		name = string(l.s[i+1 : end])
+		i = end
+	}
+	if strings.HasPrefix(name, "^") {
+		negate = !negate
+		name = name[1:]
+	}
+
+	c, ok := unicodeProperty(name)
+	if !ok {
+		syntaxError("unknown Unicode property \x1b[31m%s\x1b[0m", name)
+	}
+	c = l.fold(c)
+	if negate {
+		c = c.Negate()
+	}
+	return c, i
+}
+
+// unicodeProperty returns the character class of the Unicode general category
+// or script named name.
+func unicodeProperty(name string) (charclass.Class, bool) {
+	if name == "Any" {
+		return charclass.New(charclass.Range{Lo: 0, Hi: unicode.MaxRune}), true
+	}
+	if t, ok := unicode.Categories[name]; ok {
+		return charclass.FromTable(t), true
+	}
+	if t, ok := unicode.Scripts[name]; ok {
+		return charclass.FromTable(t), true
+	}
+	return nil, false
+}
+
+// syntaxError panics with the syntax error in the regular expression.
+func syntaxError(format string, a ...interface{}) {
+	panic(&SyntaxError{Msg: fmt.Sprintf(format, a...)})
+}
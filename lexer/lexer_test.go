@@ -0,0 +1,224 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/token"
+)
+
+// scan returns the tokens of the regular expression scanned with the flags,
+// or the syntax error.
+func scan(re string, flags Flags) (tokens []*token.Token, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return NewLexerWithFlags(re, flags).Scan(), nil
+}
+
+// describe returns the tokens written in short. (e.g. "'a' CLASS['0'-'9'] STAR")
+func describe(tokens []*token.Token) string {
+	var s []string
+	for _, t := range tokens {
+		switch t.Ty {
+		case token.CHARACTER:
+			s = append(s, fmt.Sprintf("%q", t.V))
+		case token.CLASS:
+			s = append(s, fmt.Sprintf("CLASS%v", t.Class))
+		case token.REPEAT:
+			s = append(s, fmt.Sprintf("REPEAT{%d,%d}", t.Min, t.Max))
+		case token.LPAREN:
+			if t.Group {
+				s = append(s, "GROUP")
+			} else {
+				s = append(s, "LPAREN")
+			}
+		case token.BEGIN, token.END:
+			if t.Class != nil {
+				s = append(s, fmt.Sprintf("%v%v", t.Ty, t.Class))
+			} else {
+				s = append(s, t.Ty.String())
+			}
+		default:
+			s = append(s, t.Ty.String())
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+type scanTest struct {
+	re    string
+	flags Flags
+	want  string // tokens written by describe, or "error" for a syntax error
+}
+
+func testScan(t *testing.T, tests []scanTest) {
+	t.Helper()
+	for _, tt := range tests {
+		tokens, err := scan(tt.re, tt.flags)
+		got := describe(tokens)
+		if err != nil {
+			got = "error"
+		}
+		if got != tt.want {
+			t.Errorf("Scan(%q) with flags %b = %s, want %s", tt.re, tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestScanEscape(t *testing.T) {
+	testScan(t, []scanTest{
+		{`\a\e\f\n\r\t\v`, 0, `'\a' '\x1b' '\f' '\n' '\r' '\t' '\v'`},
+		{`\x41\x{3042}\u{1F600}`, 0, `'A' 'あ' '😀'`},
+		{`\101\0\08`, 0, `'A' '\x00' '\x00' '8'`},
+		{`\*\\\.\{`, 0, `'*' '\\' '.' '{'`},
+		{`\é\٣`, 0, `'é' '٣'`},
+		{`[\x41-\x{43}\n]`, 0, `CLASS['\n''A'-'C']`},
+		{`\q`, 0, "error"},
+		{`\9`, 0, "error"},
+		{`\x4`, 0, "error"},
+		{`\xZZ`, 0, "error"},
+		{`\x{110000}`, 0, "error"},
+		{`\u{41`, 0, "error"},
+		{`a\`, 0, "error"},
+		{`[a\`, 0, "error"},
+
+		// The POSIX syntax has no escape sequence of alphanumerics.
+		{`\*\é\٣`, POSIX, `'*' 'é' '٣'`},
+		{`\n`, POSIX, "error"},
+		{`\x41`, POSIX, "error"},
+		{`\d`, POSIX, "error"},
+	})
+}
+
+func TestScanInlineFlags(t *testing.T) {
+	testScan(t, []scanTest{
+		{`(?x) a b # comment`, 0, `'a' 'b'`},
+		{"(?x)a\n#c\nb", 0, `'a' 'b'`},
+		{`(?x)a\ \#[ ]`, 0, `'a' ' ' '#' CLASS[' ']`},
+		{`a(?x: b )c d`, 0, `'a' LPAREN 'b' RPAREN 'c' ' ' 'd'`},
+		{`(a(?x) b) c`, 0, `GROUP 'a' 'b' RPAREN ' ' 'c'`},
+		{`a b`, FreeSpacing, `'a' 'b'`},
+		{`(?-x)a b`, FreeSpacing, `'a' ' ' 'b'`},
+		{`(?x-x)a b`, 0, `'a' ' ' 'b'`},
+		{`a(?#comment)b`, 0, `'a' 'b'`},
+		{`(?sm).^`, 0, `ANY BEGIN['\n']`},
+		{`(?z)`, 0, "error"},
+		{`(?x-s-m)`, 0, "error"},
+		{`(?x`, 0, "error"},
+		{`(?#comment`, 0, "error"},
+	})
+}
+
+func TestScanQuote(t *testing.T) {
+	testScan(t, []scanTest{
+		{`\Q(a+)\E*`, 0, `'(' 'a' '+' ')' STAR`},
+		{`\Q\d`, 0, `'\\' 'd'`},
+		{`\Qa b\E c`, FreeSpacing, `'a' ' ' 'b' 'c'`},
+		{`a\Eb`, 0, `'a' 'b'`},
+		{`\Qab\E`, POSIX, "error"},
+	})
+}
+
+func TestScanLineTerminators(t *testing.T) {
+	testScan(t, []scanTest{
+		{`.`, 0, `CLASS['\x00'-'\t''\v'-'\U0010ffff']`},
+		{`.`, DotAll, `ANY`},
+		{`(?s).(?-s).`, 0, `ANY CLASS['\x00'-'\t''\v'-'\U0010ffff']`},
+		{`.`, CRLF, `CLASS['\x00'-'\t''\v'-'\f''\x0e'-'\U0010ffff']`},
+		{`.`, UnicodeLineTerminators, `CLASS['\x00'-'\t''\x0e'-'\u0084''\u0086'-'‧''\u202a'-'\U0010ffff']`},
+		{`^a$`, 0, `BEGIN 'a' END`},
+		{`^a$`, MultiLine, `BEGIN['\n'] 'a' END['\n']`},
+		{`(?m)^$`, CRLF, `BEGIN['\n''\r'] END['\n''\r']`},
+		{`(?m)^`, UnicodeLineTerminators, `BEGIN['\n'-'\r''\u0085''\u2028'-'\u2029']`},
+	})
+}
+
+func TestScanRepeat(t *testing.T) {
+	testScan(t, []scanTest{
+		{`a{2}`, 0, `'a' REPEAT{2,2}`},
+		{`a{2,}`, 0, `'a' REPEAT{2,-1}`},
+		{`a{0,3}`, 0, `'a' REPEAT{0,3}`},
+		{`a{,3}`, 0, `'a' '{' ',' '3' '}'`},
+		{`a{x}b{`, 0, `'a' '{' 'x' '}' 'b' '{'`},
+		{`a{3,2}`, 0, "error"},
+	})
+}
+
+func TestScanClass(t *testing.T) {
+	testScan(t, []scanTest{
+		{`[a-c_]`, 0, `CLASS['_''a'-'c']`},
+		{`[^b]`, 0, `CLASS['\x00'-'a''c'-'\U0010ffff']`},
+		{`[]a]`, 0, `CLASS[']''a']`},
+		{`[a-]`, 0, `CLASS['-''a']`},
+		{`[[:digit:][:upper:]]`, 0, `CLASS['0'-'9''A'-'Z']`},
+		{`[[:^alpha:]&&[:digit:]]`, 0, `CLASS['0'-'9']`},
+		{`[a-z--[aeiou]]`, 0, `CLASS['b'-'d''f'-'h''j'-'n''p'-'t''v'-'z']`},
+		{`[\w&&[^\d_]--[A-Z]]`, 0, `CLASS['a'-'z']`},
+		{`\d\S`, 0, `CLASS['0'-'9'] CLASS['\x00'-'\b''\x0e'-'\x1f''!'-'\U0010ffff']`},
+		{`[[:alpha:]`, 0, "error"},
+		{`[[:foo:]]`, 0, "error"},
+		{`[z-a]`, 0, "error"},
+		{`[a&&]`, 0, `CLASS[]`},
+		{`\p{Foo}`, 0, "error"},
+		{`\p{Greek`, 0, "error"},
+		{`\p`, 0, "error"},
+	})
+}
+
+func TestScanProperty(t *testing.T) {
+	tests := []struct {
+		re        string
+		in, notIn rune
+	}{
+		{`\p{Greek}`, 'α', 'a'},
+		{`\pL`, 'あ', '1'},
+		{`\p{Lu}`, 'A', 'a'},
+		{`\P{Greek}`, 'a', 'α'},
+		{`\p{^Greek}`, 'a', 'α'},
+		{`\P{^Greek}`, 'α', 'a'},
+		{`[\p{L}--\p{Latin}]`, 'α', 'a'},
+		{`\p{Any}`, '\U0010ffff', -1},
+	}
+	for _, tt := range tests {
+		tokens, err := scan(tt.re, 0)
+		if err != nil || len(tokens) != 1 || tokens[0].Ty != token.CLASS {
+			t.Errorf("Scan(%q) = %s, %v, want a class", tt.re, describe(tokens), err)
+			continue
+		}
+		if c := tokens[0].Class; !c.Contains(tt.in) || c.Contains(tt.notIn) {
+			t.Errorf("Scan(%q): %q in the class is %v, %q in the class is %v", tt.re, tt.in, c.Contains(tt.in), tt.notIn, c.Contains(tt.notIn))
+		}
+	}
+}
+
+func TestScanCaseInsensitive(t *testing.T) {
+	testScan(t, []scanTest{
+		{`a1`, CaseInsensitive, `CLASS['A''a'] '1'`},
+		{`k`, CaseInsensitive, "CLASS['K''k''\u212a']"},
+		{`[a-c]`, CaseInsensitive, `CLASS['A'-'C''a'-'c']`},
+		{`[^a]`, CaseInsensitive, "CLASS['\\x00'-'@''B'-'`''b'-'\\U0010ffff']"},
+		{`[a-z--k]`, CaseInsensitive, `CLASS['A'-'J''L'-'Z''a'-'j''l'-'z''ſ']`},
+		{`(?i)a(?-i)a`, 0, `CLASS['A''a'] 'a'`},
+		{`(?i:\x{61})a`, 0, `LPAREN CLASS['A''a'] RPAREN 'a'`},
+	})
+}
+
+func TestScanPOSIX(t *testing.T) {
+	testScan(t, []scanTest{
+		{`[[:alpha:]]`, POSIX, `CLASS['A'-'Z''a'-'z']`},
+		{`\.a`, POSIX, `'.' 'a'`},
+		{`(?i)a`, POSIX, `GROUP QUESTION 'i' RPAREN 'a'`},
+		{`\Qa\E`, POSIX, "error"},
+		{`\d`, POSIX, "error"},
+		{`[\d]`, POSIX, "error"},
+		{`a{2}`, POSIX, `'a' REPEAT{2,2}`},
+	})
+}
@@ -8,6 +8,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		gen(os.Args[2:])
+		return
+	}
+
 	fmt.Printf("[\x1b[31m+\x1b[0m] input regex: ")
 	regex := bufio.NewScanner(os.Stdin)
 	regex.Scan()
@@ -31,7 +36,7 @@ func main() {
 			continue
 		}
 
-		if re.Match(s.Text()) {
+		if re.MatchString(s.Text()) {
 			fmt.Printf("%s => \x1b[32mMatch!\x1b[0m\n", s.Text())
 		} else {
 			fmt.Printf("%s => \x1b[31mNot match.\x1b[0m\n", s.Text())
@@ -4,6 +4,7 @@ package node
 import (
 	"fmt"
 	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
 	"github.com/8ayac/vm-regex-engine/vm/instruction"
 	"github.com/8ayac/vm-regex-engine/vm/opcode"
 )
@@ -16,7 +17,12 @@ const (
 	TypeStar      = "Star"
 	TypePlus      = "Plus"
 	TypeQuestion  = "Question"
+	TypeRepeat    = "Repeat"
+	TypeGroup     = "Group"
 	TypeAny       = "Any"
+	TypeCharClass = "CharClass"
+	TypeBegin     = "Begin"
+	TypeEnd       = "End"
 	TypeEpsilon   = "Epsilon" // Empty character
 )
 
@@ -28,6 +34,10 @@ type Node interface {
 
 	// Compile returns a byte code fragment for VM.
 	Compile() *bytecode.BC
+
+	// Nullable returns whether the subtree with Node
+	// at the top can match the empty string.
+	Nullable() bool
 }
 
 // Character represents the Character node.
@@ -64,6 +74,11 @@ func (c *Character) Compile() *bytecode.BC {
 	return bc
 }
 
+// Nullable returns whether the Character node can match the empty string.
+func (*Character) Nullable() bool {
+	return false
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Character node at the top.
 func (c *Character) SubtreeString() string {
@@ -123,6 +138,11 @@ func (u *Union) Compile() *bytecode.BC {
 	return bc
 }
 
+// Nullable returns whether the Union node can match the empty string.
+func (u *Union) Nullable() bool {
+	return u.Ope1.Nullable() || u.Ope2.Nullable()
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Union node at the top.
 func (u *Union) SubtreeString() string {
@@ -173,6 +193,11 @@ func NewConcat(ope1, ope2 Node) *Concat {
 	}
 }
 
+// Nullable returns whether the Concat node can match the empty string.
+func (c *Concat) Nullable() bool {
+	return c.Ope1.Nullable() && c.Ope2.Nullable()
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Concat node at the top.
 func (c *Concat) SubtreeString() string {
@@ -194,6 +219,17 @@ The BC compiled from an expression 'abc' will be like below:
 	|02| Jmp 0
 	|03| <nop>
 
+If the operand can match the empty string (e.g. '(a*)*'), the loop would
+never end without consuming any characters. So the BC is the one compiled
+from '(a+)?' (see Plus node), in which the loop is entered only once without
+consuming any characters:
+
+	|00| Split 1, 4
+	|01| Progress
+	|02| <operand>
+	|03| Split 1, 4
+	|04| <nop>
+
 Note:
 The bytecode is just a fragment, so when finally give VM it,
 you need to add the instruction of Match to the last of BC.
@@ -201,8 +237,14 @@ you need to add the instruction of Match to the last of BC.
 func (s *Star) Compile() *bytecode.BC {
 	bc := bytecode.NewByteCode()
 
-	e := s.Ope.Compile()
+	if s.Ope.Nullable() {
+		e := NewPlus(s.Ope).Compile()
+		bc.PushCode(*e)
+		bc.PushInst(instruction.NewInst(opcode.Split, 0, e.Code[0], e.Code[e.N-1]))
+		return bc
+	}
 
+	e := s.Ope.Compile()
 	l3 := instruction.NewInst(opcode.NOP, 0, nil, nil)
 	l2 := e.Code[0]
 	l1 := instruction.NewInst(opcode.Split, 0, l2, l3)
@@ -227,6 +269,11 @@ func NewStar(ope Node) *Star {
 	}
 }
 
+// Nullable returns whether the Star node can match the empty string.
+func (*Star) Nullable() bool {
+	return true
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Star node at the top.
 func (s *Star) SubtreeString() string {
@@ -247,6 +294,16 @@ The BC compiled from an expression 'a' will be like below:
 	|01| Split 0, 2
 	|02| <nop>
 
+If the operand can match the empty string (e.g. '(a*)+'), the BC has the instruction
+of Progress at the top of the loop, which kills the thread entering the loop again
+at the position where it entered last time. So the first iteration always runs
+(even if it consumes no characters), but the empty iterations are never repeated:
+
+	|00| Progress
+	|01| <operand>
+	|02| Split 0, 3
+	|03| <nop>
+
 Note:
 The bytecode is just a fragment, so when finally give VM it,
 you need to add the instruction of Match to the last of BC.
@@ -255,6 +312,9 @@ func (p *Plus) Compile() *bytecode.BC {
 	bc := bytecode.NewByteCode()
 
 	e := p.Ope.Compile()
+	if p.Ope.Nullable() {
+		e.PushInst(instruction.NewInst(opcode.Progress, 0, nil, nil))
+	}
 	l1 := e.Code[0]
 	l2 := instruction.NewInst(opcode.NOP, 0, nil, nil)
 
@@ -277,6 +337,11 @@ func NewPlus(ope Node) *Plus {
 	}
 }
 
+// Nullable returns whether the Plus node can match the empty string.
+func (p *Plus) Nullable() bool {
+	return p.Ope.Nullable()
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Star node at the top.
 func (p *Plus) SubtreeString() string {
@@ -327,12 +392,157 @@ func NewQuestion(ope Node) *Question {
 	}
 }
 
+// Nullable returns whether the Question node can match the empty string.
+func (*Question) Nullable() bool {
+	return true
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Star node at the top.
 func (q *Question) SubtreeString() string {
 	return fmt.Sprintf("\x1b[33m%s(%s\x1b[33m)\x1b[0m", q.Ty, q.Ope.SubtreeString())
 }
 
+// Repeat represents the Repeat node.
+type Repeat struct {
+	Ty  string
+	Ope Node
+	Min int
+	Max int // -1 for unlimited
+}
+
+/*
+Compile returns a BC compiled from Repeat node which VM can execute.
+The Repeat node is compiled as the equivalent expression without
+counted repetition, i.e. the BC compiled from an expression 'a{2,3}'
+is the same as the one compiled from 'aa(a)?' like below:
+
+	|00| Char 'a'
+	|01| Char 'a'
+	|02| Split 3, 4
+	|03| Char 'a'
+	|04| <nop>
+
+Note:
+The bytecode is just a fragment, so when finally give VM it,
+you need to add the instruction of Match to the last of BC.
+*/
+func (r *Repeat) Compile() *bytecode.BC {
+	return r.expand().Compile()
+}
+
+// expand returns the node equivalent to Repeat node which consists of
+// the copies of the operand. (e.g. 'a{2,}' to 'aaa*', 'a{1,3}' to 'a(a(a)?)?')
+func (r *Repeat) expand() Node {
+	var tail Node
+	if r.Max == -1 {
+		tail = NewStar(r.Ope)
+	} else {
+		for i := r.Min; i < r.Max; i++ {
+			if tail == nil {
+				tail = NewQuestion(r.Ope)
+			} else {
+				tail = NewQuestion(NewConcat(r.Ope, tail))
+			}
+		}
+	}
+
+	nd := tail
+	for i := 0; i < r.Min; i++ {
+		if nd == nil {
+			nd = r.Ope
+		} else {
+			nd = NewConcat(r.Ope, nd)
+		}
+	}
+	if nd == nil {
+		return NewEpsilon()
+	}
+	return nd
+}
+
+func (r *Repeat) String() string {
+	return r.SubtreeString()
+}
+
+// NewRepeat returns a new Repeat node.
+func NewRepeat(ope Node, min, max int) *Repeat {
+	return &Repeat{
+		Ty:  TypeRepeat,
+		Ope: ope,
+		Min: min,
+		Max: max,
+	}
+}
+
+// Nullable returns whether the Repeat node can match the empty string.
+func (r *Repeat) Nullable() bool {
+	return r.Min == 0 || r.Ope.Nullable()
+}
+
+// SubtreeString returns a string to which converts
+// a subtree with the Repeat node at the top.
+func (r *Repeat) SubtreeString() string {
+	return fmt.Sprintf("\x1b[33m%s{%d,%d}(%s\x1b[33m)\x1b[0m", r.Ty, r.Min, r.Max, r.Ope.SubtreeString())
+}
+
+// Group represents the Group node, which is a capturing group.
+type Group struct {
+	Ty    string
+	Ope   Node
+	Index int // group number, counted from 1 in order of the opening parentheses
+}
+
+/*
+Compile returns a BC compiled from Group node which VM can execute.
+The BC records the positions of the start and the end of the string matched
+by the operand in the capture slots 2*Index and 2*Index+1.
+The BC compiled from an expression '(a)' will be like below:
+
+	|00| Save 2
+	|01| Char 'a'
+	|02| Save 3
+
+Note:
+The bytecode is just a fragment, so when finally give VM it,
+you need to add the instruction of Match to the last of BC.
+*/
+func (g *Group) Compile() *bytecode.BC {
+	bc := bytecode.NewByteCode()
+
+	e := g.Ope.Compile()
+
+	bc.PushInst(instruction.NewSaveInst(2*g.Index + 1))
+	bc.PushCode(*e)
+	bc.PushInst(instruction.NewSaveInst(2 * g.Index))
+
+	return bc
+}
+
+func (g *Group) String() string {
+	return g.SubtreeString()
+}
+
+// NewGroup returns a new Group node.
+func NewGroup(ope Node, index int) *Group {
+	return &Group{
+		Ty:    TypeGroup,
+		Ope:   ope,
+		Index: index,
+	}
+}
+
+// Nullable returns whether the Group node can match the empty string.
+func (g *Group) Nullable() bool {
+	return g.Ope.Nullable()
+}
+
+// SubtreeString returns a string to which converts
+// a subtree with the Group node at the top.
+func (g *Group) SubtreeString() string {
+	return fmt.Sprintf("\x1b[34m%s#%d(%s\x1b[34m)\x1b[0m", g.Ty, g.Index, g.Ope.SubtreeString())
+}
+
 // Any represents the Any node.
 type Any struct {
 	Ty string
@@ -365,12 +575,152 @@ func NewAny() *Any {
 	}
 }
 
+// Nullable returns whether the Any node can match the empty string.
+func (*Any) Nullable() bool {
+	return false
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Any node at the top.
 func (a *Any) SubtreeString() string {
 	return fmt.Sprintf("\x1b[35m%s\x1b[0m", a.Ty)
 }
 
+// CharClass represents the CharClass node.
+type CharClass struct {
+	Ty string
+	C  charclass.Class
+}
+
+/*
+Compile returns a BC compiled from CharClass node which VM can execute.
+The BC compiled from an expression '[a-z]' will be like below:
+
+	|00| Class ['a'-'z']
+
+Note:
+The bytecode is just a fragment, so when finally give VM it,
+you need to add the instruction of Match to the last of BC.
+*/
+func (c *CharClass) Compile() *bytecode.BC {
+	bc := bytecode.NewByteCode()
+	bc.PushInst(instruction.NewClassInst(c.C))
+	return bc
+}
+
+func (c *CharClass) String() string {
+	return c.SubtreeString()
+}
+
+// NewCharClass returns a new CharClass node.
+func NewCharClass(c charclass.Class) *CharClass {
+	return &CharClass{
+		Ty: TypeCharClass,
+		C:  c,
+	}
+}
+
+// Nullable returns whether the CharClass node can match the empty string.
+func (*CharClass) Nullable() bool {
+	return false
+}
+
+// SubtreeString returns a string to which converts
+// a subtree with the CharClass node at the top.
+func (c *CharClass) SubtreeString() string {
+	return fmt.Sprintf("\x1b[32m%s(%v)\x1b[0m", c.Ty, c.C)
+}
+
+// Begin represents the Begin node.
+type Begin struct {
+	Ty string
+	LT charclass.Class // line terminators (nil unless multi-line)
+}
+
+/*
+Compile returns a BC compiled from Begin node which VM can execute.
+The BC compiled from an expression '^' will be like below:
+
+	|00| Begin
+
+Note:
+The bytecode is just a fragment, so when finally give VM it,
+you need to add the instruction of Match to the last of BC.
+*/
+func (b *Begin) Compile() *bytecode.BC {
+	bc := bytecode.NewByteCode()
+	bc.PushInst(instruction.NewAnchorInst(opcode.Begin, b.LT))
+	return bc
+}
+
+func (b *Begin) String() string {
+	return b.SubtreeString()
+}
+
+// NewBegin returns a new Begin node.
+func NewBegin(lt charclass.Class) *Begin {
+	return &Begin{
+		Ty: TypeBegin,
+		LT: lt,
+	}
+}
+
+// Nullable returns whether the Begin node can match the empty string.
+func (*Begin) Nullable() bool {
+	return true
+}
+
+// SubtreeString returns a string to which converts
+// a subtree with the Begin node at the top.
+func (b *Begin) SubtreeString() string {
+	return fmt.Sprintf("\x1b[35m%s\x1b[0m", b.Ty)
+}
+
+// End represents the End node.
+type End struct {
+	Ty string
+	LT charclass.Class // line terminators (nil unless multi-line)
+}
+
+/*
+Compile returns a BC compiled from End node which VM can execute.
+The BC compiled from an expression '$' will be like below:
+
+	|00| End
+
+Note:
+The bytecode is just a fragment, so when finally give VM it,
+you need to add the instruction of Match to the last of BC.
+*/
+func (e *End) Compile() *bytecode.BC {
+	bc := bytecode.NewByteCode()
+	bc.PushInst(instruction.NewAnchorInst(opcode.End, e.LT))
+	return bc
+}
+
+func (e *End) String() string {
+	return e.SubtreeString()
+}
+
+// NewEnd returns a new End node.
+func NewEnd(lt charclass.Class) *End {
+	return &End{
+		Ty: TypeEnd,
+		LT: lt,
+	}
+}
+
+// Nullable returns whether the End node can match the empty string.
+func (*End) Nullable() bool {
+	return true
+}
+
+// SubtreeString returns a string to which converts
+// a subtree with the End node at the top.
+func (e *End) SubtreeString() string {
+	return fmt.Sprintf("\x1b[35m%s\x1b[0m", e.Ty)
+}
+
 // Epsilon represents the Epsilon node.
 type Epsilon struct {
 	Ty string
@@ -394,6 +744,11 @@ func NewEpsilon() *Epsilon {
 	}
 }
 
+// Nullable returns whether the Epsilon node can match the empty string.
+func (*Epsilon) Nullable() bool {
+	return true
+}
+
 // SubtreeString returns a string to which converts
 // a subtree with the Epsilon node at the top.
 func (e *Epsilon) SubtreeString() string {
@@ -0,0 +1,34 @@
+package node
+
+import "github.com/8ayac/vm-regex-engine/bytecode"
+
+// Reverse returns a new AST which matches the reversed strings of the ones matched
+// by the argument AST, that is the AST of the regular expression read from right to left.
+// The anchors are kept as they are, because they are the conditions on the positions
+// in the text whichever direction it is read in.
+// The capturing groups are removed, so the reversed AST never records the captures.
+func Reverse(nd Node) Node {
+	switch nd := nd.(type) {
+	case *Union:
+		return NewUnion(Reverse(nd.Ope1), Reverse(nd.Ope2))
+	case *Concat:
+		return NewConcat(Reverse(nd.Ope2), Reverse(nd.Ope1))
+	case *Star:
+		return NewStar(Reverse(nd.Ope))
+	case *Plus:
+		return NewPlus(Reverse(nd.Ope))
+	case *Question:
+		return NewQuestion(Reverse(nd.Ope))
+	case *Repeat:
+		return NewRepeat(Reverse(nd.Ope), nd.Min, nd.Max)
+	case *Group:
+		return Reverse(nd.Ope)
+	}
+	return nd
+}
+
+// CompileReverse returns a BC compiled from the reversed AST of the argument AST.
+// The BC is for the VM which reads the input from right to left. (see vm.VM.SetReverse)
+func CompileReverse(nd Node) *bytecode.BC {
+	return Reverse(nd).Compile()
+}
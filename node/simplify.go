@@ -0,0 +1,298 @@
+package node
+
+import "github.com/8ayac/vm-regex-engine/charclass"
+
+// Simplify returns a new AST equivalent to the argument AST, which is rewritten
+// to be compiled into a smaller bytecode. (e.g. '(?:a|a)' to 'a', '(?:a*)*' to 'a*',
+// 'abc|abd' to 'ab[cd]', '(?:x|y|z)' to '[xyz]', 'a?a?a?' to 'a{0,3}')
+// The rewriting keeps not only the matched strings but also the priority of the
+// alternatives, so the leftmost-first matching finds the same string.
+// The operands of the capturing groups are simplified as well, but the groups are
+// kept and never rewritten across, to keep the captures. (e.g. '(a|a)' to '(a)',
+// '(x|y|z)' to '([xyz])', but '(a*)*' is kept)
+func Simplify(nd Node) Node {
+	switch nd := nd.(type) {
+	case *Union:
+		var alts []Node
+		for _, alt := range alternatives(nd) {
+			alts = append(alts, Simplify(alt))
+		}
+		return simplifyUnion(alts)
+	case *Concat:
+		var items []Node
+		for _, item := range sequence(nd) {
+			if item = Simplify(item); !isEpsilon(item) {
+				items = append(items, item)
+			}
+		}
+		items = mergeQuestions(items)
+
+		var seq Node = NewEpsilon()
+		for i := len(items) - 1; i >= 0; i-- {
+			seq = concatOf(items[i], seq)
+		}
+		return seq
+	case *Star:
+		// (a*)*, (a+)* and (a?)* to a*
+		return NewStar(unwrapRepetition(Simplify(nd.Ope)))
+	case *Plus:
+		// (a*)+ and (a?)+ to a*, (a+)+ to a+
+		ope := Simplify(nd.Ope)
+		switch ope := ope.(type) {
+		case *Star:
+			return ope
+		case *Plus:
+			return ope
+		case *Question:
+			return NewStar(ope.Ope)
+		}
+		return NewPlus(ope)
+	case *Question:
+		// (a*)? to a*, (a+)? to a*, (a?)? to a?
+		ope := Simplify(nd.Ope)
+		switch ope := ope.(type) {
+		case *Star:
+			return ope
+		case *Plus:
+			return NewStar(ope.Ope)
+		case *Question:
+			return ope
+		}
+		return NewQuestion(ope)
+	case *Repeat:
+		return NewRepeat(Simplify(nd.Ope), nd.Min, nd.Max)
+	case *Group:
+		return NewGroup(Simplify(nd.Ope), nd.Index)
+	}
+	return nd
+}
+
+// unwrapRepetition returns the operand of the argument node if it is Star, Plus or Question.
+func unwrapRepetition(nd Node) Node {
+	switch nd := nd.(type) {
+	case *Star:
+		return nd.Ope
+	case *Plus:
+		return nd.Ope
+	case *Question:
+		return nd.Ope
+	}
+	return nd
+}
+
+// simplifyUnion returns the union of the simplified alternatives in order of the priority.
+func simplifyUnion(alts []Node) Node {
+	// The alternative same as the former one never matches what the former doesn't.
+	var deduped []Node
+	for _, alt := range alts {
+		dup := false
+		for _, d := range deduped {
+			if equal(alt, d) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			deduped = append(deduped, alt)
+		}
+	}
+	alts = factorPrefix(deduped)
+	alts = mergeCharacters(alts)
+
+	nd := alts[0]
+	for _, alt := range alts[1:] {
+		nd = NewUnion(nd, alt)
+	}
+	return nd
+}
+
+// factorPrefix factors the common first character out of the adjacent alternatives.
+// (e.g. 'abc|abd|e' to 'ab(c|d)|e')
+// Only a character is factored out, because it matches the input in only one way;
+// otherwise the priority of the alternatives would change.
+func factorPrefix(alts []Node) []Node {
+	var factored []Node
+	for i := 0; i < len(alts); {
+		head, _ := splitHead(alts[i])
+		j := i + 1
+		if isCharacter(head) {
+			for j < len(alts) {
+				h, _ := splitHead(alts[j])
+				if !equal(head, h) {
+					break
+				}
+				j++
+			}
+		}
+		if j-i == 1 {
+			factored = append(factored, alts[i])
+			i++
+			continue
+		}
+
+		var tails []Node
+		for _, alt := range alts[i:j] {
+			_, tail := splitHead(alt)
+			tails = append(tails, tail)
+		}
+		factored = append(factored, concatOf(head, simplifyUnion(tails)))
+		i = j
+	}
+	return factored
+}
+
+// mergeCharacters merges the adjacent alternatives which match a character into
+// a character class. (e.g. 'a|[bc]|de' to '[abc]|de')
+func mergeCharacters(alts []Node) []Node {
+	var merged []Node
+	for i := 0; i < len(alts); {
+		j := i
+		var c charclass.Class
+		for j < len(alts) && isCharacter(alts[j]) {
+			c = c.Union(characterClass(alts[j]))
+			j++
+		}
+		if j-i < 2 {
+			merged = append(merged, alts[i])
+			i++
+			continue
+		}
+		merged = append(merged, NewCharClass(c))
+		i = j
+	}
+	return merged
+}
+
+// mergeQuestions merges the adjacent Question nodes of the same character
+// into a Repeat node. (e.g. 'a?a?a?' to 'a{0,3}')
+// The character matches the input in only one way, so the priority doesn't change.
+func mergeQuestions(items []Node) []Node {
+	var merged []Node
+	for i := 0; i < len(items); {
+		q, ok := items[i].(*Question)
+		j := i + 1
+		if ok && isCharacter(q.Ope) {
+			for j < len(items) && equal(items[j], q) {
+				j++
+			}
+		}
+		if j-i == 1 {
+			merged = append(merged, items[i])
+			i++
+			continue
+		}
+		merged = append(merged, NewRepeat(q.Ope, 0, j-i))
+		i = j
+	}
+	return merged
+}
+
+// sequence returns the operands of the nested Concat nodes in order.
+func sequence(nd Node) []Node {
+	if c, ok := nd.(*Concat); ok {
+		return append(sequence(c.Ope1), sequence(c.Ope2)...)
+	}
+	return []Node{nd}
+}
+
+// alternatives returns the alternatives of the nested Union nodes in order of the priority.
+func alternatives(nd Node) []Node {
+	if u, ok := nd.(*Union); ok {
+		return append(alternatives(u.Ope1), alternatives(u.Ope2)...)
+	}
+	return []Node{nd}
+}
+
+// splitHead returns the first node of the nested Concat nodes, and the rest of them
+// (Epsilon if nothing rests).
+func splitHead(nd Node) (Node, Node) {
+	c, ok := nd.(*Concat)
+	if !ok {
+		return nd, NewEpsilon()
+	}
+	head, tail := splitHead(c.Ope1)
+	return head, concatOf(tail, c.Ope2)
+}
+
+// concatOf returns the concatenation of the two nodes, omitting Epsilon.
+func concatOf(nd1, nd2 Node) Node {
+	if isEpsilon(nd1) {
+		return nd2
+	}
+	if isEpsilon(nd2) {
+		return nd1
+	}
+	return NewConcat(nd1, nd2)
+}
+
+// isEpsilon returns whether the node is Epsilon.
+func isEpsilon(nd Node) bool {
+	_, ok := nd.(*Epsilon)
+	return ok
+}
+
+// isCharacter returns whether the node is Character or CharClass, which matches a character.
+func isCharacter(nd Node) bool {
+	switch nd.(type) {
+	case *Character, *CharClass:
+		return true
+	}
+	return false
+}
+
+// characterClass returns the character class matched by Character or CharClass node.
+func characterClass(nd Node) charclass.Class {
+	switch nd := nd.(type) {
+	case *Character:
+		return charclass.New(charclass.Range{Lo: nd.V, Hi: nd.V})
+	case *CharClass:
+		return nd.C
+	}
+	return nil
+}
+
+// equal returns whether the two ASTs have the same structure.
+func equal(nd1, nd2 Node) bool {
+	switch nd1 := nd1.(type) {
+	case *Character:
+		nd2, ok := nd2.(*Character)
+		return ok && nd1.V == nd2.V
+	case *CharClass:
+		nd2, ok := nd2.(*CharClass)
+		return ok && nd1.C.Equal(nd2.C)
+	case *Any:
+		_, ok := nd2.(*Any)
+		return ok
+	case *Begin:
+		nd2, ok := nd2.(*Begin)
+		return ok && nd1.LT.Equal(nd2.LT)
+	case *End:
+		nd2, ok := nd2.(*End)
+		return ok && nd1.LT.Equal(nd2.LT)
+	case *Epsilon:
+		_, ok := nd2.(*Epsilon)
+		return ok
+	case *Union:
+		nd2, ok := nd2.(*Union)
+		return ok && equal(nd1.Ope1, nd2.Ope1) && equal(nd1.Ope2, nd2.Ope2)
+	case *Concat:
+		nd2, ok := nd2.(*Concat)
+		return ok && equal(nd1.Ope1, nd2.Ope1) && equal(nd1.Ope2, nd2.Ope2)
+	case *Star:
+		nd2, ok := nd2.(*Star)
+		return ok && equal(nd1.Ope, nd2.Ope)
+	case *Plus:
+		nd2, ok := nd2.(*Plus)
+		return ok && equal(nd1.Ope, nd2.Ope)
+	case *Question:
+		nd2, ok := nd2.(*Question)
+		return ok && equal(nd1.Ope, nd2.Ope)
+	case *Repeat:
+		nd2, ok := nd2.(*Repeat)
+		return ok && nd1.Min == nd2.Min && nd1.Max == nd2.Max && equal(nd1.Ope, nd2.Ope)
+	case *Group:
+		nd2, ok := nd2.(*Group)
+		return ok && nd1.Index == nd2.Index && equal(nd1.Ope, nd2.Ope)
+	}
+	return false
+}
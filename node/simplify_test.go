@@ -0,0 +1,123 @@
+package node_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+func parse(t *testing.T, re string) node.Node {
+	ast, err := parser.NewParser(re).Parse()
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	return ast
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		re   string
+		want string
+	}{
+		{`(?:a|a)`, `a`},
+		{`(?:a*)*`, `a*`},
+		{`(?:a+)+`, `a+`},
+		{`(?:a?)+`, `a*`},
+		{`(?:a+)?`, `a*`},
+		{`abc|abd`, `ab[cd]`},
+		{`(?:x|y|z)`, `[xyz]`},
+		{`a?a?a?`, `a{0,3}`},
+		{`(a|a)`, `(a)`},
+		{`(x|y|z)`, `([xyz])`},
+		{`(a*)*`, `(a*)*`},
+		{`a|ab`, `a(?:|b)`},
+	}
+	for _, tt := range tests {
+		got := node.Simplify(parse(t, tt.re))
+		want := node.Simplify(parse(t, tt.want))
+		if got.SubtreeString() != want.SubtreeString() {
+			t.Errorf("Simplify(%q) = %v, want %v", tt.re, got.SubtreeString(), want.SubtreeString())
+		}
+	}
+}
+
+// randomPattern returns a random regular expression nested up to depth,
+// which has the alternatives and the repetitions Simplify rewrites.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", "ab", "abc", "abd", "[ab]", "x", "^", "$", "a|a", "a?a?"}
+	if depth == 0 || rnd.Intn(4) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(7) {
+	case 0:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 1:
+		return "(?:" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	case 2:
+		return "(" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	case 3:
+		return "(?:(?:" + randomPattern(rnd, depth-1) + ")*)*"
+	case 4:
+		return "(?:(?:" + randomPattern(rnd, depth-1) + ")?)+"
+	case 5:
+		return "(" + randomPattern(rnd, depth-1) + ")+"
+	}
+	return "(?:" + randomPattern(rnd, depth-1) + ")?"
+}
+
+// randomInput returns a random string of the runes which the random patterns use.
+func randomInput(rnd *rand.Rand) string {
+	runes := []rune("abcdx")
+	s := make([]rune, rnd.Intn(8))
+	for i := range s {
+		s[i] = runes[rnd.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+func newVM(t *testing.T, ast node.Node, longest bool) *vm.VM {
+	bc := ast.Compile()
+	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+	bc.Optimize()
+	v, err := vm.NewVM(bc)
+	if err != nil {
+		t.Fatalf("%v: %v", ast.SubtreeString(), err)
+	}
+	v.SetLongest(longest)
+	return v
+}
+
+// The simplified AST must match the same strings with the same captures as the original one.
+func TestSimplifyEquivalence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var inputs []string
+	for i := 0; i < 30; i++ {
+		inputs = append(inputs, randomInput(rnd))
+	}
+	for i := 0; i < 1000; i++ {
+		re := randomPattern(rnd, 4)
+		ast := parse(t, re)
+		simplified := node.Simplify(ast)
+
+		for _, longest := range []bool{false, true} {
+			v1, v2 := newVM(t, ast, longest), newVM(t, simplified, longest)
+			for _, s := range inputs {
+				input := append([]rune(s), '\x00')
+				want, err1 := v1.SearchSubmatch(input, nil)
+				got, err2 := v2.SearchSubmatch(input, nil)
+				if err1 != nil || err2 != nil {
+					t.Fatalf("%q on %q: %v, %v", re, s, err1, err2)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%q (longest %v) on %q: %v after Simplify, %v before", re, longest, s, got, want)
+				}
+			}
+		}
+	}
+}
@@ -10,28 +10,62 @@ import (
 	"github.com/8ayac/vm-regex-engine/token"
 )
 
-// Parser has a slice of tokens to parse, and now looking token.
+// Parser has a lexer to obtain tokens, a slice of tokens to parse, and now looking token.
+// It also counts the capturing groups to number them in order of their opening parentheses.
 type Parser struct {
+	lex    *lexer.Lexer
 	tokens []*token.Token
 	look   *token.Token
+	groups int
 }
 
-// NewParser returns a new Parser with the tokens to
-// parse that were obtained by scanning.
+// NewParser returns a new Parser which parses the tokens
+// that will be obtained by scanning.
 func NewParser(s string) *Parser {
-	p := &Parser{
-		tokens: lexer.NewLexer(s).Scan(),
+	return NewParserWithFlags(s, 0)
+}
+
+// NewParserWithFlags returns a new Parser which parses the tokens
+// that will be obtained by scanning with the argument flags.
+func NewParserWithFlags(s string, flags lexer.Flags) *Parser {
+	return &Parser{
+		lex: lexer.NewLexerWithFlags(s, flags),
 	}
-	p.move()
-	return p
 }
 
 // GetAST returns the root node of AST obtained by parsing.
+// If the regular expression has a syntax error, GetAST aborts with its message.
 func (psr *Parser) GetAST() node.Node {
-	ast := psr.expression()
+	ast, err := psr.Parse()
+	if err != nil {
+		log.Fatal(err)
+	}
 	return ast
 }
 
+// Parse returns the root node of AST obtained by parsing,
+// or the syntax error if the regular expression has it.
+func (psr *Parser) Parse() (ast node.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*lexer.SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+
+	psr.tokens = psr.lex.Scan()
+	psr.move()
+	return psr.expression(), nil
+}
+
+// NumGroups returns the number of the capturing groups in the parsed regular expression.
+func (psr *Parser) NumGroups() int {
+	return psr.groups
+}
+
 // move updates the now looking token to the next token in token slice.
 // If token slice is empty, will set token.EOF as now looking token.
 func (psr *Parser) move() {
@@ -47,8 +81,8 @@ func (psr *Parser) move() {
 // now looking Token type is an expected (or not).
 func (psr *Parser) moveWithValidation(expect token.Type) {
 	if psr.look.Ty != expect {
-		err := fmt.Sprintf("[syntax error] expect:\x1b[31m%s\x1b[0m actual:\x1b[31m%s\x1b[0m", expect, psr.look.Ty)
-		log.Fatal(err)
+		msg := fmt.Sprintf("expect:\x1b[31m%s\x1b[0m actual:\x1b[31m%s\x1b[0m", expect, psr.look.Ty)
+		panic(&lexer.SyntaxError{Msg: msg})
 	}
 	psr.move()
 }
@@ -81,7 +115,7 @@ func (psr *Parser) subexpr() node.Node {
 
 // seq -> subseq | ε
 func (psr *Parser) seq() node.Node {
-	if psr.look.Ty == token.LPAREN || psr.look.Ty == token.CHARACTER || psr.look.Ty == token.ANY {
+	if psr.lookFactor() {
 		return psr.subseq()
 	}
 	return node.NewEpsilon()
@@ -94,14 +128,14 @@ func (psr *Parser) seq() node.Node {
 // )
 func (psr *Parser) subseq() node.Node {
 	nd := psr.sufope()
-	if psr.look.Ty == token.LPAREN || psr.look.Ty == token.CHARACTER || psr.look.Ty == token.ANY {
+	if psr.lookFactor() {
 		nd2 := psr.subseq()
 		return node.NewConcat(nd, nd2)
 	}
 	return nd
 }
 
-// sufope -> factor ('*'|'+'|'?') | factor
+// sufope -> factor ('*'|'+'|'?'|REPEAT) | factor
 func (psr *Parser) sufope() node.Node {
 	nd := psr.factor()
 	switch psr.look.Ty {
@@ -114,22 +148,56 @@ func (psr *Parser) sufope() node.Node {
 	case token.QUESTION:
 		psr.move()
 		return node.NewQuestion(nd)
+	case token.REPEAT:
+		min, max := psr.look.Min, psr.look.Max
+		psr.move()
+		return node.NewRepeat(nd, min, max)
 	}
 	return nd
 }
 
-// factor -> '(' subexpr ')' | ANY | CHARACTER |
+// lookFactor returns whether now looking token can be the beginning of factor.
+func (psr *Parser) lookFactor() bool {
+	switch psr.look.Ty {
+	case token.LPAREN, token.CHARACTER, token.ANY, token.CLASS, token.BEGIN, token.END:
+		return true
+	}
+	return false
+}
+
+// factor -> '(' subexpr ')' | ANY | CLASS | BEGIN | END | CHARACTER |
 func (psr *Parser) factor() node.Node {
 	switch psr.look.Ty {
 	case token.LPAREN:
+		group := psr.look.Group
+		index := 0
+		if group {
+			psr.groups++
+			index = psr.groups
+		}
 		psr.moveWithValidation(token.LPAREN)
 		nd := psr.subexpr()
 		psr.moveWithValidation(token.RPAREN)
+		if group {
+			return node.NewGroup(nd, index)
+		}
 		return nd
 	case token.ANY:
 		nd := node.NewAny()
 		psr.moveWithValidation(token.ANY)
 		return nd
+	case token.CLASS:
+		nd := node.NewCharClass(psr.look.Class)
+		psr.moveWithValidation(token.CLASS)
+		return nd
+	case token.BEGIN:
+		nd := node.NewBegin(psr.look.Class)
+		psr.moveWithValidation(token.BEGIN)
+		return nd
+	case token.END:
+		nd := node.NewEnd(psr.look.Class)
+		psr.moveWithValidation(token.END)
+		return nd
 	default:
 		nd := node.NewCharacter(psr.look.V)
 		psr.moveWithValidation(token.CHARACTER)
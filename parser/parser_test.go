@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/lexer"
+	"github.com/8ayac/vm-regex-engine/node"
+)
+
+type parseTest struct {
+	re     string
+	flags  lexer.Flags
+	want   string // regular expression which must be parsed into the same AST
+	groups int
+}
+
+func testParse(t *testing.T, tests []parseTest) {
+	t.Helper()
+	for _, tt := range tests {
+		psr := NewParserWithFlags(tt.re, tt.flags)
+		got, err := psr.Parse()
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.re, err)
+			continue
+		}
+		want, err := NewParser(tt.want).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.want, err)
+		}
+		if got.SubtreeString() != want.SubtreeString() {
+			t.Errorf("Parse(%q) = %v, want %v", tt.re, got.SubtreeString(), want.SubtreeString())
+		}
+		if psr.NumGroups() != tt.groups {
+			t.Errorf("Parse(%q): %d groups, want %d", tt.re, psr.NumGroups(), tt.groups)
+		}
+	}
+}
+
+// testParseError checks whether Parse returns *lexer.SyntaxError for each regular expression.
+func testParseError(t *testing.T, flags lexer.Flags, res ...string) {
+	t.Helper()
+	for _, re := range res {
+		_, err := NewParserWithFlags(re, flags).Parse()
+		var e *lexer.SyntaxError
+		if !errors.As(err, &e) {
+			t.Errorf("Parse(%q) = %v, want *lexer.SyntaxError", re, err)
+		}
+	}
+}
+
+func TestParseInlineFlags(t *testing.T) {
+	testParse(t, []parseTest{
+		{`(?x) ( a ) (?: b ) ( c ) # comment`, 0, `(a)(?:b)(c)`, 2},
+		{`(?x: a b )|c d`, 0, `(?:ab)|c d`, 0},
+		{`((?x) a b ) c`, 0, `(ab) c`, 1},
+		{`a(?#(b)c`, 0, `ac`, 0},
+		{`a b`, lexer.FreeSpacing, `ab`, 0},
+		{`\Q(a)\E(b)`, 0, `\(a\)(b)`, 1},
+	})
+	testParseError(t, 0, `(?x: a`, `(?x) a )`, `(?q)`, `(?x`, `(?-)-)`)
+}
+
+func TestParseLineTerminators(t *testing.T) {
+	tests := []struct {
+		re    string
+		flags lexer.Flags
+		begin string // line terminators of Begin, or "" if it matches only at the beginning of the text
+		end   string
+	}{
+		{`^$`, 0, "", ""},
+		{`(?m:^)$`, 0, `['\n']`, ""},
+		{`^(?m:$)`, lexer.CRLF, "", `['\n''\r']`},
+		{`^$`, lexer.MultiLine | lexer.UnicodeLineTerminators, `['\n'-'\r''\u0085''\u2028'-'\u2029']`, `['\n'-'\r''\u0085''\u2028'-'\u2029']`},
+	}
+	for _, tt := range tests {
+		ast, err := NewParserWithFlags(tt.re, tt.flags).Parse()
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.re, err)
+		}
+		c, ok := ast.(*node.Concat)
+		if !ok {
+			t.Fatalf("Parse(%q) = %v, want Concat", tt.re, ast.SubtreeString())
+		}
+		begin, ok1 := c.Ope1.(*node.Begin)
+		end, ok2 := c.Ope2.(*node.End)
+		if !ok1 || !ok2 {
+			t.Fatalf("Parse(%q) = %v, want Concat(Begin, End)", tt.re, ast.SubtreeString())
+		}
+		if got := lineTerminators(begin.LT); got != tt.begin {
+			t.Errorf("Parse(%q): Begin with %s, want %s", tt.re, got, tt.begin)
+		}
+		if got := lineTerminators(end.LT); got != tt.end {
+			t.Errorf("Parse(%q): End with %s, want %s", tt.re, got, tt.end)
+		}
+	}
+}
+
+// lineTerminators returns the class of the line terminators as a string, or "" if it is nil.
+func lineTerminators(c charclass.Class) string {
+	if c == nil {
+		return ""
+	}
+	return c.String()
+}
+
+func TestParseRepeat(t *testing.T) {
+	testParse(t, []parseTest{
+		{`a{2}b`, 0, `(?:a{2,2})b`, 0},
+		{`(ab){1,}`, 0, `(ab){1,}`, 1},
+		{`(a(b)){0,3}c`, 0, `(a(b)){0,3}c`, 2},
+		{`(?i)a{2}`, 0, `[Aa]{2}`, 0},
+		{`a{,3}`, 0, `a\{,3\}`, 0},
+		{`(a){2`, 0, `(a)\{2`, 1},
+	})
+	testParseError(t, 0, `a{3,2}`, `{2}`, `a|{2}`, `a{2}{3}`)
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	testParseError(t, 0, `(a`, `a)`, `a**`, `*a`, `[z-a]`, `[a`, `\p{Foo}`, `\x{110000}`, `\k`, `a\`)
+	testParseError(t, lexer.POSIX, `(?:a)`, `(?i)a`, `\Qa\E`, `\d`)
+}
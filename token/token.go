@@ -1,7 +1,11 @@
 // Package token provides tokens for parsing the regular expressions.
 package token
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/8ayac/vm-regex-engine/charclass"
+)
 
 // Type is integer to identify the type of token.
 type Type int
@@ -14,6 +18,10 @@ const (
 	PLUS
 	QUESTION
 	ANY
+	CLASS
+	REPEAT
+	BEGIN
+	END
 	LPAREN
 	RPAREN
 	EOF
@@ -37,6 +45,14 @@ func (k Type) String() string {
 		return "RPAREN"
 	case ANY:
 		return "ANY"
+	case CLASS:
+		return "CLASS"
+	case REPEAT:
+		return "REPEAT"
+	case BEGIN:
+		return "BEGIN"
+	case END:
+		return "END"
 	case EOF:
 		return "EOF"
 	default:
@@ -46,11 +62,18 @@ func (k Type) String() string {
 
 // Token represents a token.
 type Token struct {
-	V  rune // token value
-	Ty Type // token type
+	V     rune            // token value
+	Ty    Type            // token type
+	Class charclass.Class // token value for CLASS, line terminators for BEGIN and END
+	Min   int             // minimum count for REPEAT
+	Max   int             // maximum count for REPEAT (-1 for unlimited)
+	Group bool            // whether LPAREN opens a capturing group
 }
 
 func (t *Token) String() string {
+	if t.Ty == CLASS {
+		return fmt.Sprintf("V -> \x1b[32m%v\x1b[0m\tKind -> \x1b[32m%v\x1b[0m", t.Class, t.Ty)
+	}
 	return fmt.Sprintf("V -> \x1b[32m%v\x1b[0m\tKind -> \x1b[32m%v\x1b[0m", string(t.V), t.Ty)
 }
 
@@ -61,3 +84,20 @@ func NewToken(value rune, k Type) *Token {
 		Ty: k,
 	}
 }
+
+// NewGroupToken returns a new Token of LPAREN which opens a capturing group.
+func NewGroupToken() *Token {
+	return &Token{
+		V:     '(',
+		Ty:    LPAREN,
+		Group: true,
+	}
+}
+
+// NewClassToken returns a new Token of CLASS which has the argument character class.
+func NewClassToken(c charclass.Class) *Token {
+	return &Token{
+		Ty:    CLASS,
+		Class: c,
+	}
+}
@@ -0,0 +1,370 @@
+// Package asm provides the assembly language of the bytecode for VM,
+// and converts bytecodes to it and back.
+//
+// Each line has an instruction, optionally preceded by a label, and the jump targets are
+// written as labels, so that the text doesn't depend on the addresses of the instructions.
+// The operands are written in the Go syntax of the literals, and a character class is
+// a list of runes or ranges of runes in brackets. The runes which can't be written
+// in the literals (e.g. the surrogate halves) are written as the integers instead,
+// and so is the string which has them, as a list of runes. (e.g. string 'a' 0xd800)
+// The comments start with '//'.
+//
+//	L0: save 2
+//	L1: split L2, L4
+//	L2: class ['a'-'z''_']
+//	L3: jmp L1
+//	L4: string "end"
+//	L5: begin ['\n']
+//	L6: save 3
+//	L7: match
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// mnemonics maps each mnemonic to its opcode.
+var mnemonics = map[string]opcode.Opcode{
+	"char":     opcode.Char,
+	"match":    opcode.Match,
+	"jmp":      opcode.Jmp,
+	"split":    opcode.Split,
+	"any":      opcode.ANY,
+	"class":    opcode.Class,
+	"begin":    opcode.Begin,
+	"end":      opcode.End,
+	"progress": opcode.Progress,
+	"save":     opcode.Save,
+	"string":   opcode.String,
+	"nop":      opcode.NOP,
+}
+
+// Disassemble returns the assembly of the argument bytecode. Each instruction is labeled
+// with its position in the bytecode. (e.g. "L3: split L4, L7")
+// The jump targets which don't exist in the bytecode are written as "L?".
+func Disassemble(bc *bytecode.BC) string {
+	index := map[*instruction.Inst]int{}
+	for i, inst := range bc.Code {
+		index[inst] = i
+	}
+	label := func(inst *instruction.Inst) string {
+		if i, ok := index[inst]; ok {
+			return fmt.Sprintf("L%d", i)
+		}
+		return "L?"
+	}
+
+	var sb strings.Builder
+	for i, inst := range bc.Code {
+		fmt.Fprintf(&sb, "L%d: %s", i, strings.ToLower(inst.Opcode.String()))
+		switch inst.Opcode {
+		case opcode.Char:
+			fmt.Fprintf(&sb, " %s", runeLit(inst.C))
+		case opcode.Jmp:
+			fmt.Fprintf(&sb, " %s", label(inst.X))
+		case opcode.Split:
+			fmt.Fprintf(&sb, " %s, %s", label(inst.X), label(inst.Y))
+		case opcode.Class:
+			fmt.Fprintf(&sb, " %s", classLit(inst.Class))
+		case opcode.Begin, opcode.End:
+			if inst.Class != nil {
+				fmt.Fprintf(&sb, " %s", classLit(inst.Class))
+			}
+		case opcode.Save:
+			fmt.Fprintf(&sb, " %d", inst.N)
+		case opcode.String:
+			sb.WriteString(" " + stringLit(inst.S))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// runeLit returns the Go literal of the rune, or the integer if it is not a valid rune.
+func runeLit(r rune) string {
+	if utf8.ValidRune(r) {
+		return fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("%#x", r)
+}
+
+// stringLit returns the Go literal of the runes, or the list of the rune literals
+// if any of them is not a valid rune. (e.g. 'a' 0xd800)
+func stringLit(rs []rune) string {
+	valid := true
+	lits := make([]string, len(rs))
+	for i, r := range rs {
+		valid = valid && utf8.ValidRune(r)
+		lits[i] = runeLit(r)
+	}
+	if valid {
+		return strconv.Quote(string(rs))
+	}
+	return strings.Join(lits, " ")
+}
+
+// classLit returns the character class in brackets. (e.g. ['0'-'9'])
+// An integer is separated from the next item by a space, so that they are not
+// scanned as one integer. (e.g. [0xd800 'a'])
+func classLit(c charclass.Class) string {
+	s := "["
+	for _, r := range c {
+		if !strings.HasSuffix(s, "[") && !strings.HasSuffix(s, "'") {
+			s += " "
+		}
+		s += runeLit(r.Lo)
+		if r.Lo != r.Hi {
+			s += "-" + runeLit(r.Hi)
+		}
+	}
+	return s + "]"
+}
+
+// Assemble returns the bytecode assembled from the argument assembly.
+// If the assembly has a syntax error, or the bytecode doesn't pass bytecode.Verify,
+// it returns the error instead.
+func Assemble(src string) (*bytecode.BC, error) {
+	a := &assembler{
+		labels: map[string]*instruction.Inst{},
+	}
+	a.s.Init(strings.NewReader(src))
+	a.s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanChars | scanner.ScanStrings | scanner.ScanComments | scanner.SkipComments
+	a.s.Whitespace = 1<<'\t' | 1<<'\r' | 1<<' '
+	a.s.Error = func(s *scanner.Scanner, msg string) {
+		if a.err == nil {
+			a.err = a.errorf("%s", msg)
+		}
+	}
+	a.next()
+
+	bc := bytecode.NewByteCode()
+	for a.tok != scanner.EOF && a.err == nil {
+		if inst := a.line(); inst != nil && a.err == nil {
+			bc.AddInst(inst, bc.N)
+		}
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+
+	for _, j := range a.jumps {
+		inst, ok := a.labels[j.label]
+		if !ok {
+			return nil, fmt.Errorf("asm: line %d: undefined label %s", j.line, j.label)
+		}
+		*j.dst = inst
+	}
+	if len(a.pending) > 0 {
+		return nil, fmt.Errorf("asm: label %s has no instruction", a.pending[0])
+	}
+	if err := bytecode.Verify(bc); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// assembler has the state of the assembling.
+type assembler struct {
+	s       scanner.Scanner
+	tok     rune                         // current token
+	text    string                       // text of the current token
+	labels  map[string]*instruction.Inst // instruction of each label
+	pending []string                     // labels waiting for the next instruction
+	jumps   []jump                       // jump targets to be resolved
+	err     error                        // first error
+}
+
+// jump represents a jump target to be resolved after all the labels are defined.
+type jump struct {
+	dst   **instruction.Inst
+	label string
+	line  int
+}
+
+// next reads the next token.
+func (a *assembler) next() {
+	a.tok = a.s.Scan()
+	a.text = a.s.TokenText()
+}
+
+// errorf returns the error at the current line.
+func (a *assembler) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("asm: line %d: %s", a.s.Position.Line, fmt.Sprintf(format, args...))
+}
+
+// fail records the error if no error has been recorded.
+func (a *assembler) fail(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = a.errorf(format, args...)
+	}
+}
+
+// expect reads the token if it is tok, otherwise records the error.
+func (a *assembler) expect(tok rune, what string) string {
+	text := a.text
+	if a.tok != tok {
+		a.fail("expected %s, found %q", what, text)
+		return ""
+	}
+	a.next()
+	return text
+}
+
+// line reads a line, and returns the instruction on it or nil if there is none.
+func (a *assembler) line() *instruction.Inst {
+	if a.tok == '\n' {
+		a.next()
+		return nil
+	}
+
+	name := a.expect(scanner.Ident, "label or mnemonic")
+	if a.tok == ':' {
+		a.next()
+		if _, ok := a.labels[name]; ok {
+			a.fail("label %s redefined", name)
+			return nil
+		}
+		a.labels[name] = nil
+		a.pending = append(a.pending, name)
+		if a.tok == '\n' || a.tok == scanner.EOF {
+			return nil
+		}
+		name = a.expect(scanner.Ident, "mnemonic")
+	}
+	if a.err != nil {
+		return nil
+	}
+
+	inst := a.instruction(name)
+	if a.err != nil {
+		return nil
+	}
+	if a.tok != '\n' && a.tok != scanner.EOF {
+		a.fail("unexpected %q after the instruction", a.text)
+		return nil
+	}
+	for _, l := range a.pending {
+		a.labels[l] = inst
+	}
+	a.pending = nil
+	return inst
+}
+
+// instruction reads the operands of the mnemonic, and returns the instruction.
+func (a *assembler) instruction(name string) *instruction.Inst {
+	op, ok := mnemonics[name]
+	if !ok {
+		a.fail("unknown mnemonic %s", name)
+		return nil
+	}
+
+	inst := &instruction.Inst{Opcode: op}
+	switch op {
+	case opcode.Char:
+		inst.C = a.char()
+	case opcode.Jmp:
+		a.label(&inst.X)
+	case opcode.Split:
+		a.label(&inst.X)
+		a.expect(',', "','")
+		a.label(&inst.Y)
+	case opcode.Class:
+		inst.Class = a.class()
+	case opcode.Begin, opcode.End:
+		if a.tok == '[' {
+			inst.Class = a.class()
+		}
+	case opcode.Save:
+		text := a.expect(scanner.Int, "slot number")
+		if a.err == nil {
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				a.fail("invalid slot number %s", text)
+			}
+			inst.N = n
+		}
+	case opcode.String:
+		if a.tok != scanner.String {
+			// The list of the runes, which has the ones not valid in the string literal.
+			for a.err == nil && a.tok != '\n' && a.tok != scanner.EOF {
+				inst.S = append(inst.S, a.char())
+			}
+			if a.err == nil && len(inst.S) == 0 {
+				a.fail("expected string literal, found %q", a.text)
+			}
+			break
+		}
+		text := a.expect(scanner.String, "string literal")
+		if a.err == nil {
+			s, err := strconv.Unquote(text)
+			if err != nil || s == "" {
+				a.fail("invalid string literal %s", text)
+			}
+			inst.S = []rune(s)
+		}
+	}
+	return inst
+}
+
+// label reads a label, which will be resolved to the instruction set to dst.
+func (a *assembler) label(dst **instruction.Inst) {
+	line := a.s.Position.Line
+	if l := a.expect(scanner.Ident, "label"); a.err == nil {
+		a.jumps = append(a.jumps, jump{dst: dst, label: l, line: line})
+	}
+}
+
+// char reads a rune literal, or an integer up to unicode.MaxRune.
+func (a *assembler) char() rune {
+	if a.tok == scanner.Int {
+		text := a.text
+		a.next()
+		n, err := strconv.ParseInt(text, 0, 32)
+		if err != nil || n > unicode.MaxRune {
+			a.fail("invalid rune %s", text)
+			return 0
+		}
+		return rune(n)
+	}
+	text := a.expect(scanner.Char, "rune literal")
+	if a.err != nil {
+		return 0
+	}
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		a.fail("invalid rune literal %s", text)
+		return 0
+	}
+	return []rune(s)[0]
+}
+
+// class reads a character class, which is a list of runes or ranges in brackets.
+// (e.g. ['0'-'9'], ['_'])
+func (a *assembler) class() charclass.Class {
+	a.expect('[', "'['")
+	var rs []charclass.Range
+	for a.err == nil && a.tok != ']' {
+		lo := a.char()
+		hi := lo
+		if a.tok == '-' {
+			a.next()
+			hi = a.char()
+		}
+		if a.err == nil && lo > hi {
+			a.fail("invalid range %s-%s", runeLit(lo), runeLit(hi))
+		}
+		rs = append(rs, charclass.Range{Lo: lo, Hi: hi})
+	}
+	a.expect(']', "']'")
+	return charclass.New(rs...)
+}
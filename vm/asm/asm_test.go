@@ -0,0 +1,215 @@
+package asm
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// compile returns the program of the regular expression, as well as vmregex.CompileWithOptions.
+func compile(t *testing.T, re string) *bytecode.BC {
+	ast, err := parser.NewParser(re).Parse()
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	bc := node.Simplify(ast).Compile()
+	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+	bc.Optimize()
+	return bc
+}
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		re   string
+		want string
+	}{
+		{`(a|b)*c`, `
+L0: split L1, L5
+L1: save 2
+L2: class ['a'-'b']
+L3: save 3
+L4: jmp L0
+L5: char 'c'
+L6: match
+`},
+		{`a+`, `
+L0: char 'a'
+L1: split L0, L2
+L2: match
+`},
+		{`ab?`, `
+L0: char 'a'
+L1: split L2, L3
+L2: char 'b'
+L3: match
+`},
+		{`[0-9]x$`, `
+L0: class ['0'-'9']
+L1: char 'x'
+L2: end
+L3: match
+`},
+		{`(?m)^ab`, `
+L0: begin ['\n']
+L1: string "ab"
+L2: match
+`},
+		{`[\x{D800}-\x{DFFF}a]\x{D800}`, `
+L0: class ['a'0xd800-0xdfff]
+L1: char 0xd800
+L2: match
+`},
+		{`a\x{DFFF}[\x{D800}\x{DC00}]`, `
+L0: string 'a' 0xdfff
+L1: class [0xd800 0xdc00]
+L2: match
+`},
+	}
+	for _, tt := range tests {
+		want := strings.TrimPrefix(tt.want, "\n")
+		if got := Disassemble(compile(t, tt.re)); got != want {
+			t.Errorf("%q: Disassemble() = \n%s\nwant\n%s", tt.re, got, want)
+		}
+	}
+
+	bc := compile(t, `ab?`)
+	bc.Code[1].X = instruction.NewInst(opcode.Match, 0, nil, nil)
+	if got := Disassemble(bc); !strings.Contains(got, "L1: split L?, L3\n") {
+		t.Errorf("the jump to the outside of the bytecode is disassembled as \n%s", got)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := `// a+b
+start:  char 'a'   // loop
+	split start, next
+next:
+	string "b"
+	match
+`
+	bc, err := Assemble(src)
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	want := "L0: char 'a'\nL1: split L0, L2\nL2: string \"b\"\nL3: match\n"
+	if got := Disassemble(bc); got != want {
+		t.Errorf("assembled as \n%s\nwant\n%s", got, want)
+	}
+	v, err := vm.NewVM(bc)
+	if err != nil {
+		t.Fatalf("NewVM: %v", err)
+	}
+	if got := v.Run([]rune("aab\x00"), 0); got != 3 {
+		t.Errorf("Run(%q) = %d, want 3", "aab", got)
+	}
+}
+
+func TestAssembleError(t *testing.T) {
+	tests := []string{
+		"foo\n",
+		"jmp L9\nmatch",
+		"char 'a' 'b'\nmatch",
+		"L1: match\nL1: match",
+		"save x\nmatch",
+		"class ['z'-'a']\nmatch",
+		"char 'a'",
+		"L0:",
+		"string \"\"\nmatch",
+		"split L0\nL0: match",
+		"save 2000000000\nmatch",
+		"char 0x110000\nmatch",
+		"class [0xdfff-0xd800]\nmatch",
+		"string\nmatch",
+		"string 'a' x\nmatch",
+	}
+	for _, src := range tests {
+		if _, err := Assemble(src); err == nil {
+			t.Errorf("Assemble(%q) returns no error", src)
+		}
+	}
+}
+
+// randomPattern returns a random regular expression nested up to depth.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", "ab", "'", `"`, `\\`, `\n`, "[a-c_]", "[^a]", ".", "^", "$", "()"}
+	if depth == 0 || rnd.Intn(4) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(4) {
+	case 0, 1:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 2:
+		return "(" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	}
+	quantifiers := []string{"*", "+", "?", "{2,3}"}
+	return "(?:" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+}
+
+// operands returns the operands of the instruction except the jump targets as a string,
+// in which the runes are written as the integers.
+func operands(inst *instruction.Inst) string {
+	switch inst.Opcode {
+	case opcode.Char:
+		return fmt.Sprint(inst.Opcode, inst.C)
+	case opcode.Save:
+		return fmt.Sprint(inst.Opcode, inst.N)
+	case opcode.String:
+		return fmt.Sprint(inst.Opcode, inst.S)
+	}
+	return fmt.Sprint(inst.Opcode, []charclass.Range(inst.Class))
+}
+
+// The assembly of Disassemble must be assembled back into the same bytecode.
+func TestRoundTrip(t *testing.T) {
+	patterns := []string{`(a|b)*c`, `abc|abd`, `(?m)^a$`, `a{2,4}`, `\.\n'"`, `(x*)*y|()`, `[^\x00-\x{10FFFF}]`,
+		`[\x{D800}-\x{DFFF}]`, `[^\x{D800}]+`, `\x{D800}\x{DC00}`, `a\x{DFFF}|\x{D800}b`}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		patterns = append(patterns, randomPattern(rnd, 4))
+	}
+	inputs := []string{"", "a", "ab", "abc", "b\n'\"", "_x", "\\c", "aaaa"}
+
+	for _, re := range patterns {
+		bc := compile(t, re)
+		src := Disassemble(bc)
+		assembled, err := Assemble(src)
+		if err != nil {
+			t.Fatalf("%q: Assemble: %v\n%s", re, err, src)
+		}
+		if got := Disassemble(assembled); got != src {
+			t.Errorf("%q: assembled as \n%s\nwant\n%s", re, got, src)
+			continue
+		}
+		for i, inst := range assembled.Code {
+			if got, want := operands(inst), operands(bc.Code[i]); got != want {
+				t.Errorf("%q: %02d: assembled as %s, want %s", re, i, got, want)
+			}
+		}
+
+		v1, err := vm.NewVM(bc)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		v2, err := vm.NewVM(assembled)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		for _, s := range inputs {
+			input := append([]rune(s), '\x00')
+			want, _ := v1.SearchSubmatch(input, nil)
+			got, _ := v2.SearchSubmatch(input, nil)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%q on %q: assembled one matches %v, want %v", re, s, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMatchBudgetExceeded is returned when a matching exceeds its step budget or time budget.
+var ErrMatchBudgetExceeded = errors.New("match budget exceeded")
+
+// checkInterval is the number of steps between the checks of the context and the clock.
+const checkInterval = 256
+
+// Budget represents the limits on a matching, which the VM checks while running.
+// A Budget is shared by all the runs for the same matching (e.g. the runs from
+// each start position), so the steps are counted in total.
+type Budget struct {
+	ctx      context.Context
+	maxSteps int       // maximum number of steps (0 for unlimited)
+	deadline time.Time // zero for unlimited
+	steps    int       // number of steps spent
+}
+
+// NewBudget returns a new Budget which allows maxSteps steps (0 for unlimited)
+// and timeout duration (0 for unlimited) until ctx is done.
+func NewBudget(ctx context.Context, maxSteps int, timeout time.Duration) *Budget {
+	b := &Budget{
+		ctx:      ctx,
+		maxSteps: maxSteps,
+	}
+	if timeout > 0 {
+		b.deadline = time.Now().Add(timeout)
+	}
+	return b
+}
+
+// Steps returns the number of steps spent so far.
+func (b *Budget) Steps() int {
+	return b.steps
+}
+
+// Spend consumes a step, and returns ErrMatchBudgetExceeded if the budget has run out,
+// or the error of the context if it is done.
+// The context and the clock are checked only once in checkInterval steps.
+func (b *Budget) Spend() error {
+	b.steps++
+	if b.maxSteps > 0 && b.steps > b.maxSteps {
+		return ErrMatchBudgetExceeded
+	}
+	if b.steps%checkInterval == 0 {
+		if err := b.ctx.Err(); err != nil {
+			return err
+		}
+		if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+			return ErrMatchBudgetExceeded
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package dfa
+
+import (
+	"errors"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/vm"
+)
+
+// ErrTooManyStates is returned when the DFA needs more states than the limit.
+var ErrTooManyStates = errors.New("the DFA has too many states")
+
+// ErrInvalidTable is returned when the table given to FromTable can't be run safely.
+var ErrInvalidTable = errors.New("the table of DFA is invalid")
+
+// DFA represents a complete DFA built from the bytecode in advance, which is
+// minimized and runs by looking up the table of transitions.
+// Once the DFA reaches an accepting state, it stays there whatever it reads,
+// because the input is known to match.
+type DFA struct {
+	prog   *program
+	table  []int  // table[s*len(alphabet)+c] is the state reached from the state s by reading a rune in the class c
+	accept []bool // whether each state is accepting
+	start  int
+}
+
+// New returns a new minimized DFA which runs the argument bytecode.
+// If the DFA needs more than maxStates states before the minimization, it returns
+// ErrTooManyStates, and if the bytecode has an instruction which DFA can't execute,
+// it returns ErrUnsupported.
+func New(bc *bytecode.BC, maxStates int) (*DFA, error) {
+	d, err := determinize(bc, maxStates)
+	if err != nil {
+		return nil, err
+	}
+	d.minimize()
+	return d, nil
+}
+
+// determinize returns a new DFA which runs the argument bytecode, before the minimization.
+func determinize(bc *bytecode.BC, maxStates int) (*DFA, error) {
+	p, err := newProgram(bc)
+	if err != nil {
+		return nil, err
+	}
+	k := len(p.alphabet)
+
+	// Subset construction: the state 0 is the accepting state, and the others
+	// are the sets of instructions found by following the transitions from the start.
+	d := &DFA{
+		prog:   p,
+		table:  make([]int, k),
+		accept: []bool{true},
+	}
+	var sets [][]int
+	ids := map[string]int{}
+	add := func(pcs map[int]bool) (int, error) {
+		sorted, key := sortedPCs(pcs)
+		if id, ok := ids[key]; ok {
+			return id, nil
+		}
+		if len(d.accept) >= maxStates {
+			return 0, ErrTooManyStates
+		}
+		id := len(d.accept)
+		ids[key] = id
+		sets = append(sets, sorted)
+		d.table = append(d.table, make([]int, k)...)
+		d.accept = append(d.accept, false)
+		return id, nil
+	}
+
+	pcs := map[int]bool{}
+	if !p.closure(0, pcs) {
+		if d.start, err = add(pcs); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < len(sets); i++ {
+		s := i + 1
+		for c := 0; c < k; c++ {
+			next, match := p.step(sets[i], c, true)
+			if match {
+				continue // to the accepting state 0
+			}
+			if d.table[s*k+c], err = add(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return d, nil
+}
+
+// Table returns the alphabet (the lower bounds of the rune classes), the transitions,
+// whether each state is accepting, and the start state of the DFA.
+// FromTable builds the same DFA from them without the bytecode.
+// They must not be modified, because the DFA shares them.
+func (d *DFA) Table() (alphabet []rune, table []int, accept []bool, start int) {
+	return d.prog.alphabet, d.table, d.accept, d.start
+}
+
+// FromTable returns a new DFA which has the argument table, as returned by DFA.Table.
+// The table is checked only whether the DFA can run it safely, that is,
+// the alphabet starts with 0 and is sorted, and every transition and the start state
+// are the states in the table. Otherwise, it returns ErrInvalidTable.
+func FromTable(alphabet []rune, table []int, accept []bool, start int) (*DFA, error) {
+	if len(alphabet) == 0 || alphabet[0] != 0 || len(accept) == 0 || len(table) != len(accept)*len(alphabet) {
+		return nil, ErrInvalidTable
+	}
+	for i := 1; i < len(alphabet); i++ {
+		if alphabet[i] <= alphabet[i-1] {
+			return nil, ErrInvalidTable
+		}
+	}
+	if start < 0 || start >= len(accept) {
+		return nil, ErrInvalidTable
+	}
+	for _, s := range table {
+		if s < 0 || s >= len(accept) {
+			return nil, ErrInvalidTable
+		}
+	}
+	return &DFA{
+		prog:   &program{alphabet: alphabet},
+		table:  table,
+		accept: accept,
+		start:  start,
+	}, nil
+}
+
+// NumStates returns the number of the states in the DFA.
+func (d *DFA) NumStates() int {
+	return len(d.accept)
+}
+
+// Match returns whether the input runes have a string matching the regular expression.
+// It stops the matching when the budget b has run out (or its context is done),
+// and then returns the error. If b is nil, the matching is not limited by the budget.
+func (d *DFA) Match(input []rune, b *vm.Budget) (bool, error) {
+	k := len(d.prog.alphabet)
+	s := d.start
+	if d.accept[s] {
+		return true, nil
+	}
+	for _, r := range input {
+		if b != nil {
+			if err := b.Spend(); err != nil {
+				return false, err
+			}
+		}
+		s = d.table[s*k+d.prog.classOf(r)]
+		if d.accept[s] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// minimize merges the equivalent states in the DFA by Hopcroft's algorithm.
+// The states are split into blocks repeatedly, until the states in each block
+// can't be distinguished by any input, and then each block becomes a state.
+func (d *DFA) minimize() {
+	n, k := len(d.accept), len(d.prog.alphabet)
+
+	// pre[c][t] is the states which reach the state t by reading a rune in the class c.
+	pre := make([][][]int, k)
+	for c := range pre {
+		pre[c] = make([][]int, n)
+	}
+	for s := 0; s < n; s++ {
+		for c := 0; c < k; c++ {
+			t := d.table[s*k+c]
+			pre[c][t] = append(pre[c][t], s)
+		}
+	}
+
+	// Start with the blocks of the accepting states and the others.
+	block := make([]int, n)
+	blocks := [][]int{{}, {}}
+	for s := 0; s < n; s++ {
+		if !d.accept[s] {
+			block[s] = 1
+		}
+		blocks[block[s]] = append(blocks[block[s]], s)
+	}
+	if len(blocks[1]) == 0 {
+		blocks = blocks[:1]
+	}
+	work := []int{0}
+	inWork := map[int]bool{0: true}
+
+	for len(work) > 0 {
+		a := work[len(work)-1]
+		work = work[:len(work)-1]
+		delete(inWork, a)
+
+		// The block a itself may be split while splitting the blocks by it,
+		// so the splitter is the copy of its states at this point.
+		splitter := append([]int(nil), blocks[a]...)
+		for c := 0; c < k; c++ {
+			// x is the states which reach the splitter by reading a rune in the class c.
+			x := map[int]bool{}
+			for _, t := range splitter {
+				for _, s := range pre[c][t] {
+					x[s] = true
+				}
+			}
+
+			hit := map[int][]int{}
+			for s := range x {
+				hit[block[s]] = append(hit[block[s]], s)
+			}
+			for y, in := range hit {
+				if len(in) == len(blocks[y]) {
+					continue
+				}
+				// Split the block y into the states in x and the others.
+				var out []int
+				for _, s := range blocks[y] {
+					if !x[s] {
+						out = append(out, s)
+					}
+				}
+				z := len(blocks)
+				blocks[y] = in
+				blocks = append(blocks, out)
+				for _, s := range out {
+					block[s] = z
+				}
+
+				if inWork[y] || len(out) < len(in) {
+					work = append(work, z)
+					inWork[z] = true
+				} else {
+					work = append(work, y)
+					inWork[y] = true
+				}
+			}
+		}
+	}
+
+	table := make([]int, len(blocks)*k)
+	accept := make([]bool, len(blocks))
+	for b, states := range blocks {
+		s := states[0]
+		accept[b] = d.accept[s]
+		for c := 0; c < k; c++ {
+			table[b*k+c] = block[d.table[s*k+c]]
+		}
+	}
+	d.table = table
+	d.accept = accept
+	d.start = block[d.start]
+}
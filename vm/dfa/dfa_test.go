@@ -0,0 +1,98 @@
+package dfa
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// randomPattern returns a random regular expression nested up to depth.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", "x", "1", ".", "[ab]", `\d`, "[^a1]"}
+	if depth == 0 || rnd.Intn(4) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(4) {
+	case 0, 1:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 2:
+		return "(?:" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	}
+	quantifiers := []string{"*", "+", "?", "{2}"}
+	return "(?:" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+}
+
+// randomInput returns a random string of the runes which the random patterns use.
+func randomInput(rnd *rand.Rand) string {
+	runes := []rune("abx1c\n")
+	s := make([]rune, rnd.Intn(8))
+	for i := range s {
+		s[i] = runes[rnd.Intn(len(runes))]
+	}
+	return string(s)
+}
+
+// compile returns the program of the regular expression, as well as vmregex.CompileWithOptions.
+func compile(t *testing.T, re string) *bytecode.BC {
+	ast, err := parser.NewParser(re).Parse()
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	bc := node.Simplify(ast).Compile()
+	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+	bc.Optimize()
+	return bc
+}
+
+// The minimized DFA must match the same strings as the DFA before the minimization and VM.
+func TestMinimize(t *testing.T) {
+	patterns := []string{`(?:.b|11)x`, `(?:.[ab]|\d1\d)x`}
+	inputs := []string{"bbx", "cbbx", "zbx", "\nccbbxa", "11x", "1b1x", "x"}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		patterns = append(patterns, randomPattern(rnd, 4))
+	}
+	for i := 0; i < 20; i++ {
+		inputs = append(inputs, randomInput(rnd))
+	}
+
+	for _, re := range patterns {
+		bc := compile(t, re)
+		d, err := determinize(bc, 10000)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		min, err := New(bc, 10000)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		if min.NumStates() > d.NumStates() {
+			t.Errorf("%q: %d states after the minimization, %d before", re, min.NumStates(), d.NumStates())
+		}
+		v, err := vm.NewVM(bc)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+
+		for _, s := range inputs {
+			input := append([]rune(s), '\x00')
+			start, _, err := v.Search(input, nil)
+			if err != nil {
+				t.Fatalf("%q on %q: %v", re, s, err)
+			}
+			want := start != -1
+			if got, _ := d.Match(input[:len(input)-1], nil); got != want {
+				t.Errorf("%q on %q: DFA before the minimization = %v, VM = %v", re, s, got, want)
+			}
+			if got, _ := min.Match(input[:len(input)-1], nil); got != want {
+				t.Errorf("%q on %q: minimized DFA = %v, VM = %v", re, s, got, want)
+			}
+		}
+	}
+}
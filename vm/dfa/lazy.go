@@ -0,0 +1,182 @@
+package dfa
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/vm"
+)
+
+// ErrCacheThrashed is returned when the lazy DFA builds new states so often that
+// the cache of the states is flushed before it pays. The caller should use VM instead.
+var ErrCacheThrashed = errors.New("the cache of DFA states thrashed")
+
+// cacheSize is the maximum number of the transitions cached in the lazy DFA.
+const cacheSize = 1 << 16
+
+// minRunesPerState is the minimum number of runes which the lazy DFA should read
+// per state built since the last flush. If it reads fewer runes, the cache is thrashing.
+const minRunesPerState = 10
+
+// Lazy represents a lazy DFA, whose states are built from the bytecode
+// only when the matching reaches them, and cached up to a limit.
+type Lazy struct {
+	prog      *program
+	maxStates int
+	caches    sync.Pool // caches of the states reused across the matchings (*cache)
+}
+
+// state represents a state of the lazy DFA.
+type state struct {
+	pcs   []int    // instructions which the threads of VM are at
+	match bool     // whether a matched string ends at the position of the state
+	next  []*state // transition for each rune class (nil if not built yet)
+}
+
+// cache has the states of the lazy DFA built so far.
+type cache struct {
+	states map[string]*state
+	start  *state
+}
+
+// NewLazy returns a new lazy DFA which runs the argument bytecode.
+// If the bytecode has an instruction which DFA can't execute, it returns ErrUnsupported.
+func NewLazy(bc *bytecode.BC) (*Lazy, error) {
+	p, err := newProgram(bc)
+	if err != nil {
+		return nil, err
+	}
+
+	maxStates := cacheSize / len(p.alphabet)
+	if maxStates < 16 {
+		maxStates = 16
+	}
+	return &Lazy{
+		prog:      p,
+		maxStates: maxStates,
+	}, nil
+}
+
+// Match returns whether the input runes have a string matching the regular expression.
+// It stops the matching when the budget b has run out (or its context is done),
+// or when the cache of the states thrashes, and then returns the error.
+// If b is nil, the matching is not limited by the budget.
+func (d *Lazy) Match(input []rune, b *vm.Budget) (bool, error) {
+	matched := false
+	err := d.run(input, b, func(int) bool {
+		matched = true
+		return false
+	})
+	return matched, err
+}
+
+// MatchEnds returns the positions in the input runes where any matched strings end,
+// in ascending order. It reads the whole input, and stops the matching with the error
+// as well as Match.
+func (d *Lazy) MatchEnds(input []rune, b *vm.Budget) ([]int, error) {
+	var ends []int
+	err := d.run(input, b, func(end int) bool {
+		ends = append(ends, end)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ends, nil
+}
+
+// run reads the input runes with the lazy DFA, and calls found with the position
+// each time a matched string ends there, until found returns false.
+func (d *Lazy) run(input []rune, b *vm.Budget, found func(end int) bool) error {
+	c := d.getCache()
+	defer d.caches.Put(c)
+
+	s := c.start
+	if s.match && !found(0) {
+		return nil
+	}
+
+	read := 0 // runes read since the last flush
+	for i, r := range input {
+		if b != nil {
+			if err := b.Spend(); err != nil {
+				return err
+			}
+		}
+		read++
+
+		class := d.prog.classOf(r)
+		next := s.next[class]
+		if next == nil {
+			if len(c.states) >= d.maxStates {
+				if read < minRunesPerState*len(c.states) {
+					return ErrCacheThrashed
+				}
+				s = c.flush(d, s)
+				read = 0
+			}
+			next = c.build(d, s, class)
+			s.next[class] = next
+		}
+		s = next
+		if s.match && !found(i+1) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// getCache returns a cache of the states, which may be reused from the previous matchings.
+func (d *Lazy) getCache() *cache {
+	if c, ok := d.caches.Get().(*cache); ok {
+		return c
+	}
+	c := &cache{}
+	c.flush(d, nil)
+	return c
+}
+
+// flush removes all the states from the cache except the start state and the state s,
+// and returns the state s added to the cache again.
+func (c *cache) flush(d *Lazy, s *state) *state {
+	c.states = map[string]*state{}
+
+	pcs := map[int]bool{}
+	match := d.prog.closure(0, pcs)
+	c.start = c.add(d, pcs, match)
+
+	if s == nil {
+		return nil
+	}
+	pcs = map[int]bool{}
+	for _, pc := range s.pcs {
+		pcs[pc] = true
+	}
+	return c.add(d, pcs, s.match)
+}
+
+// build returns the state reached from the state s by reading a rune in the class.
+func (c *cache) build(d *Lazy, s *state, class int) *state {
+	pcs, match := d.prog.step(s.pcs, class, true)
+	return c.add(d, pcs, match)
+}
+
+// add returns the state which has the set of instructions pcs and the flag match,
+// adding it to the cache if it is not there yet.
+func (c *cache) add(d *Lazy, pcs map[int]bool, match bool) *state {
+	sorted, key := sortedPCs(pcs)
+	if match {
+		key += "$"
+	}
+	if s, ok := c.states[key]; ok {
+		return s
+	}
+	s := &state{
+		pcs:   sorted,
+		match: match,
+		next:  make([]*state, len(d.prog.alphabet)),
+	}
+	c.states[key] = s
+	return s
+}
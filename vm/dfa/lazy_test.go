@@ -0,0 +1,169 @@
+package dfa
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/vm"
+)
+
+// vmEnds returns the positions in s where the strings matching the regular expression end,
+// by running VM for the expression anchored at the end on each prefix of s.
+func vmEnds(t *testing.T, re, s string) []int {
+	v, err := vm.NewVM(compile(t, "(?:"+re+")$"))
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	var ends []int
+	input := []rune(s)
+	for end := 0; end <= len(input); end++ {
+		start, _, err := v.Search(append(input[:end:end], '\x00'), nil)
+		if err != nil {
+			t.Fatalf("%q on %q: %v", re, s, err)
+		}
+		if start != -1 {
+			ends = append(ends, end)
+		}
+	}
+	return ends
+}
+
+// The lazy DFA must find the same ends of the matched strings as VM.
+func TestLazy(t *testing.T) {
+	patterns := []string{`a`, `ab|b`, `a*`, `[ab]*a[ab]`, `\d+x`, `(?:.b|11)x`}
+	inputs := []string{"", "a", "bab", "aab", "12x1x", "\nbx11x"}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		patterns = append(patterns, randomPattern(rnd, 3))
+	}
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, randomInput(rnd))
+	}
+
+	for _, re := range patterns {
+		d, err := NewLazy(compile(t, re))
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		for _, s := range inputs {
+			want := vmEnds(t, re, s)
+			got, err := d.MatchEnds([]rune(s), nil)
+			if err != nil {
+				t.Fatalf("%q on %q: %v", re, s, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%q on %q: MatchEnds = %v, VM = %v", re, s, got, want)
+			}
+			if matched, err := d.Match([]rune(s), nil); err != nil || matched != (len(want) > 0) {
+				t.Errorf("%q on %q: Match = %v, %v, want %v", re, s, matched, err, len(want) > 0)
+			}
+		}
+	}
+}
+
+// numStates returns the number of the states which the lazy DFA builds
+// to read the input runes without flushing the cache.
+func numStates(d *Lazy, input []rune) int {
+	c := &cache{}
+	c.flush(d, nil)
+	s := c.start
+	for _, r := range input {
+		class := d.prog.classOf(r)
+		if s.next[class] == nil {
+			s.next[class] = c.build(d, s, class)
+		}
+		s = s.next[class]
+	}
+	return len(c.states)
+}
+
+// The lazy DFA flushes the cache when it is full of the states, and goes on matching
+// if it has read enough runes since the last flush. Otherwise, it gives up the matching
+// with ErrCacheThrashed.
+func TestLazyFlush(t *testing.T) {
+	const re = `[ab]*a[ab]{3}`
+	bc := compile(t, re)
+	const maxStates = 8
+
+	// The runs of 'b' keep the DFA in a cached state, between the new states
+	// built by the other runes.
+	var sb strings.Builder
+	for i := 0; i < 16; i++ {
+		sb.WriteString(strings.Repeat("b", 100))
+		for j := 3; j >= 0; j-- {
+			sb.WriteString([]string{"b", "a"}[i>>j&1])
+		}
+	}
+	rnd := rand.New(rand.NewSource(1))
+	thrashing := make([]rune, 200)
+	for i := range thrashing {
+		thrashing[i] = []rune("ab")[rnd.Intn(2)]
+	}
+
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{sb.String(), nil},
+		{string(thrashing), ErrCacheThrashed},
+	}
+	for _, tt := range tests {
+		d, err := NewLazy(bc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := numStates(d, []rune(tt.input)); n <= maxStates {
+			t.Fatalf("%q on %d runes: only %d states are built", re, len(tt.input), n)
+		}
+		d.maxStates = maxStates
+
+		got, err := d.MatchEnds([]rune(tt.input), nil)
+		if err != tt.err {
+			t.Errorf("MatchEnds(%d runes) = %v, want %v", len(tt.input), err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want := vmEnds(t, re, tt.input); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("MatchEnds(%d runes) = %v, VM = %v", len(tt.input), got, want)
+		}
+	}
+}
+
+// NewLazy limits the number of the states by the size of the alphabet,
+// so that the cache has at most about cacheSize transitions.
+func TestNewLazyMaxStates(t *testing.T) {
+	for _, re := range []string{`a`, `[a-c]x[d-f]y[g-i]z`, `\w\d\s`} {
+		d, err := NewLazy(compile(t, re))
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		tooMany := d.maxStates*len(d.prog.alphabet) > cacheSize && d.maxStates > 16
+		if d.maxStates < 16 || tooMany {
+			t.Errorf("%q: %d states for %d classes", re, d.maxStates, len(d.prog.alphabet))
+		}
+	}
+}
+
+func TestLazyBudget(t *testing.T) {
+	d, err := NewLazy(compile(t, `ab`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := []rune(strings.Repeat("a", 1000) + "b")
+	if _, err := d.Match(input, vm.NewBudget(context.Background(), 100, 0)); err != vm.ErrMatchBudgetExceeded {
+		t.Errorf("Match with 100 steps = %v, want %v", err, vm.ErrMatchBudgetExceeded)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.Match(input, vm.NewBudget(ctx, 0, 0)); err != context.Canceled {
+		t.Errorf("Match with the canceled context = %v, want %v", err, context.Canceled)
+	}
+	if matched, err := d.Match(input, vm.NewBudget(context.Background(), len(input), 0)); !matched || err != nil {
+		t.Errorf("Match with %d steps = %v, %v, want true", len(input), matched, err)
+	}
+}
@@ -0,0 +1,171 @@
+// Package dfa provides DFA executors built from the bytecode for VM.
+// A DFA can tell whether the input matches the regular expression faster than VM,
+// but it can't tell where the matched string is.
+// For Details: https://swtch.com/~rsc/regexp/regexp3.html
+package dfa
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// ErrUnsupported is returned when the bytecode has an instruction which DFA can't execute.
+// (e.g. Begin and End, which depend on the characters around the position)
+var ErrUnsupported = errors.New("the bytecode is not supported by DFA")
+
+// program is the bytecode analyzed to build the states of DFA.
+// Each state of DFA is a set of the instructions which the threads of VM can be at
+// after reading the same input.
+type program struct {
+	code     []*instruction.Inst
+	index    map[*instruction.Inst]int // position of each instruction in code
+	alphabet []rune                    // lower bounds of the rune classes
+}
+
+// newProgram returns a new program analyzed from the argument bytecode.
+// Each String instruction is expanded into the Char instructions,
+// because DFA reads one rune at each step.
+func newProgram(bc *bytecode.BC) (*program, error) {
+	p := &program{
+		code:  expandString(bc.Code),
+		index: map[*instruction.Inst]int{},
+	}
+
+	pc := 0
+	for _, inst := range bc.Code {
+		p.index[inst] = pc
+		if inst.Opcode == opcode.String {
+			pc += len(inst.S)
+		} else {
+			pc++
+		}
+	}
+
+	bounds := map[rune]bool{0: true}
+	for _, inst := range p.code {
+		switch inst.Opcode {
+		case opcode.Begin, opcode.End:
+			return nil, ErrUnsupported
+		case opcode.Char:
+			bounds[inst.C] = true
+			bounds[inst.C+1] = true
+		case opcode.Class:
+			for _, r := range inst.Class {
+				bounds[r.Lo] = true
+				bounds[r.Hi+1] = true
+			}
+		}
+	}
+
+	for r := range bounds {
+		p.alphabet = append(p.alphabet, r)
+	}
+	sort.Slice(p.alphabet, func(i, j int) bool {
+		return p.alphabet[i] < p.alphabet[j]
+	})
+	return p, nil
+}
+
+// expandString returns the instructions in which each String instruction
+// is replaced with the Char instructions of its runes.
+func expandString(code []*instruction.Inst) []*instruction.Inst {
+	expanded := make([]*instruction.Inst, 0, len(code))
+	for _, inst := range code {
+		if inst.Opcode != opcode.String {
+			expanded = append(expanded, inst)
+			continue
+		}
+		for _, c := range inst.S {
+			expanded = append(expanded, instruction.NewInst(opcode.Char, c, nil, nil))
+		}
+	}
+	return expanded
+}
+
+// classOf returns the rune class which r belongs to.
+// All the runes in the same class are never distinguished by the program,
+// so DFA has the transitions for each class instead of each rune.
+func (p *program) classOf(r rune) int {
+	return sort.Search(len(p.alphabet), func(i int) bool {
+		return p.alphabet[i] > r
+	}) - 1
+}
+
+// closure adds to the set pcs the instructions reachable from the instruction at pc
+// without reading any characters, and returns whether Match is reachable.
+// Only the instructions which read a character are added to the set.
+func (p *program) closure(pc int, pcs map[int]bool) bool {
+	seen := map[int]bool{}
+	match := false
+
+	var visit func(pc int)
+	visit = func(pc int) {
+		if pc >= len(p.code) || seen[pc] {
+			return
+		}
+		seen[pc] = true
+
+		inst := p.code[pc]
+		switch inst.Opcode {
+		case opcode.Match:
+			match = true
+		case opcode.Jmp:
+			visit(p.index[inst.X])
+		case opcode.Split:
+			visit(p.index[inst.X])
+			visit(p.index[inst.Y])
+		case opcode.NOP, opcode.Progress, opcode.Save:
+			// Progress only stops the empty loops, which never matter in a set of instructions,
+			// and DFA doesn't record the positions saved by Save.
+			visit(pc + 1)
+		default:
+			pcs[pc] = true
+		}
+	}
+	visit(pc)
+	return match
+}
+
+// step returns the set of instructions reached by reading a rune in the class c
+// from the instructions in the set pcs, and whether Match is reachable after that.
+// If unanchored is true, the threads starting at the next position are also added.
+func (p *program) step(pcs []int, c int, unanchored bool) (map[int]bool, bool) {
+	r := p.alphabet[c]
+	next := map[int]bool{}
+	match := false
+	for _, pc := range pcs {
+		inst := p.code[pc]
+		switch {
+		case inst.Opcode == opcode.Char && inst.C == r,
+			inst.Opcode == opcode.ANY,
+			inst.Opcode == opcode.Class && inst.Class.Contains(r):
+			match = p.closure(pc+1, next) || match
+		}
+	}
+	if unanchored {
+		match = p.closure(0, next) || match
+	}
+	return next, match
+}
+
+// sortedPCs returns the instructions in the set pcs in order, and the key which
+// identifies the set.
+func sortedPCs(pcs map[int]bool) ([]int, string) {
+	sorted := make([]int, 0, len(pcs))
+	for pc := range pcs {
+		sorted = append(sorted, pc)
+	}
+	sort.Ints(sorted)
+
+	key := make([]string, len(sorted))
+	for i, pc := range sorted {
+		key[i] = strconv.Itoa(pc)
+	}
+	return sorted, strings.Join(key, ",")
+}
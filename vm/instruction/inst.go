@@ -4,15 +4,19 @@ package instruction
 
 import (
 	"fmt"
+	"github.com/8ayac/vm-regex-engine/charclass"
 	"github.com/8ayac/vm-regex-engine/vm/opcode"
 )
 
 // Inst represents a instruction which is executable with the VM.
 type Inst struct {
 	Opcode opcode.Opcode
-	C      rune  // operand for Char
-	X      *Inst // operand for Jmp, Split
-	Y      *Inst // operand for Split
+	C      rune            // operand for Char
+	X      *Inst           // operand for Jmp, Split
+	Y      *Inst           // operand for Split
+	Class  charclass.Class // operand for Class, Begin, End
+	N      int             // operand for Save (index of the capture slot)
+	S      []rune          // operand for String
 }
 
 func (inst Inst) String() string {
@@ -27,6 +31,19 @@ func (inst Inst) String() string {
 		return fmt.Sprintf("Split %p(%+v), %p(%+v)", inst.X, inst.X, inst.Y, inst.Y)
 	case opcode.ANY:
 		return fmt.Sprintf("ANY")
+	case opcode.Class:
+		return fmt.Sprintf("Class %v", inst.Class)
+	case opcode.Begin, opcode.End:
+		if inst.Class == nil {
+			return fmt.Sprintf("%v", inst.Opcode)
+		}
+		return fmt.Sprintf("%v %v", inst.Opcode, inst.Class)
+	case opcode.Progress:
+		return fmt.Sprintf("Progress")
+	case opcode.Save:
+		return fmt.Sprintf("Save %d", inst.N)
+	case opcode.String:
+		return fmt.Sprintf("String %q", string(inst.S))
 	case opcode.NOP:
 		return fmt.Sprintf("<nop>")
 	}
@@ -42,3 +59,30 @@ func NewInst(op opcode.Opcode, c rune, x, y *Inst) *Inst {
 		Y:      y,
 	}
 }
+
+// NewClassInst returns a new Inst of Class which matches a rune in the argument character class.
+func NewClassInst(c charclass.Class) *Inst {
+	return &Inst{
+		Opcode: opcode.Class,
+		Class:  c,
+	}
+}
+
+// NewAnchorInst returns a new Inst of the anchor (Begin or End).
+// The argument lt is the line terminators in the multi-line mode, or nil
+// if the anchor matches only at the edge of the text.
+func NewAnchorInst(op opcode.Opcode, lt charclass.Class) *Inst {
+	return &Inst{
+		Opcode: op,
+		Class:  lt,
+	}
+}
+
+// NewSaveInst returns a new Inst of Save which records the current position
+// in the capture slot n.
+func NewSaveInst(n int) *Inst {
+	return &Inst{
+		Opcode: opcode.Save,
+		N:      n,
+	}
+}
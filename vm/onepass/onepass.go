@@ -0,0 +1,241 @@
+// Package onepass provides an executor for the one-pass programs.
+// A program is one-pass if it is anchored at the beginning of the text, and at each Split
+// the next character (or the end of the text) tells which branch can proceed.
+// Such a program can be executed in a single forward scan with only one thread,
+// which records the capture slots without backtracking or thread lists.
+// For Details: https://swtch.com/~rsc/regexp/regexp3.html
+package onepass
+
+import (
+	"errors"
+	"unicode"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// ErrNotOnePass is returned when the bytecode is not one-pass.
+var ErrNotOnePass = errors.New("the bytecode is not one-pass")
+
+// OnePass represents an executor of a one-pass program.
+type OnePass struct {
+	code     []*instruction.Inst
+	index    map[*instruction.Inst]int // position of each instruction in code
+	choices  map[int]*choice           // choice for each Split instruction
+	progress map[int]int               // index of the record for each Progress instruction
+	ncap     int                       // number of the capture slots
+}
+
+// choice has what can be read first after taking each branch of a Split instruction.
+type choice struct {
+	x, y       charclass.Class // characters which can be read first
+	xEnd, yEnd bool            // whether the end of the text can be reached first
+}
+
+// New returns a new OnePass which executes the argument bytecode.
+// If the bytecode is not one-pass, it returns ErrNotOnePass.
+func New(bc *bytecode.BC) (*OnePass, error) {
+	p := &OnePass{
+		code:     bc.Code,
+		index:    map[*instruction.Inst]int{},
+		choices:  map[int]*choice{},
+		progress: map[int]int{},
+		ncap:     2,
+	}
+	for i, inst := range bc.Code {
+		p.index[inst] = i
+		switch inst.Opcode {
+		case opcode.Begin, opcode.End:
+			// The anchors of lines depend on the characters around the position.
+			if inst.Class != nil {
+				return nil, ErrNotOnePass
+			}
+		case opcode.Progress:
+			p.progress[i] = len(p.progress)
+		case opcode.Save:
+			if inst.N >= p.ncap {
+				p.ncap = inst.N + 1
+			}
+		}
+	}
+	if !p.anchored() {
+		return nil, ErrNotOnePass
+	}
+
+	for i, inst := range bc.Code {
+		if inst.Opcode != opcode.Split {
+			continue
+		}
+		c := &choice{}
+		var ok bool
+		if c.x, c.xEnd, ok = p.first(p.index[inst.X]); !ok {
+			return nil, ErrNotOnePass
+		}
+		if c.y, c.yEnd, ok = p.first(p.index[inst.Y]); !ok {
+			return nil, ErrNotOnePass
+		}
+		if len(c.x.Intersect(c.y)) > 0 || c.xEnd && c.yEnd {
+			return nil, ErrNotOnePass
+		}
+		p.choices[i] = c
+	}
+	return p, nil
+}
+
+// anchored returns whether the program starts with Begin, which matches only
+// at the beginning of the text.
+func (p *OnePass) anchored() bool {
+	for pc := 0; pc < len(p.code); {
+		switch inst := p.code[pc]; inst.Opcode {
+		case opcode.Begin:
+			return true
+		case opcode.Jmp:
+			pc = p.index[inst.X]
+		case opcode.Save, opcode.NOP:
+			pc++
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// first returns the characters which can be read first from the instruction at pc,
+// and whether the end of the text can be reached first (by End).
+// It returns false if Match can be reached without reading anything, because
+// whether to stop there or to go on reading can't be told by the next character.
+// Begin is passed through, so the characters may be more than the ones really read.
+func (p *OnePass) first(pc int) (charclass.Class, bool, bool) {
+	seen := map[int]bool{}
+	var class charclass.Class
+	end := false
+
+	var visit func(pc int) bool
+	visit = func(pc int) bool {
+		if pc >= len(p.code) || seen[pc] {
+			return true
+		}
+		seen[pc] = true
+
+		switch inst := p.code[pc]; inst.Opcode {
+		case opcode.Char:
+			class = class.Union(charclass.New(charclass.Range{Lo: inst.C, Hi: inst.C}))
+		case opcode.String:
+			class = class.Union(charclass.New(charclass.Range{Lo: inst.S[0], Hi: inst.S[0]}))
+		case opcode.ANY:
+			class = class.Union(charclass.New(charclass.Range{Lo: 0, Hi: unicode.MaxRune}))
+		case opcode.Class:
+			class = class.Union(inst.Class)
+		case opcode.End:
+			end = true
+		case opcode.Match:
+			return false
+		case opcode.Jmp:
+			return visit(p.index[inst.X])
+		case opcode.Split:
+			return visit(p.index[inst.X]) && visit(p.index[inst.Y])
+		case opcode.Begin, opcode.Progress, opcode.Save, opcode.NOP:
+			return visit(pc + 1)
+		}
+		return true
+	}
+	ok := visit(pc)
+	return class, end, ok
+}
+
+// Match executes the matching for the input runes from the beginning.
+// The input must be terminated by '\x00', which is never matched as a character.
+// If the matching was success the return value would be the capture slots
+// as well as vm.VM.SearchSubmatch, otherwise nil.
+// It stops the matching when the budget b has run out (or its context is done),
+// and then returns the error. If b is nil, the matching is not limited by the budget.
+func (p *OnePass) Match(input []rune, b *vm.Budget) ([]int, error) {
+	caps := make([]int, p.ncap)
+	for i := range caps {
+		caps[i] = -1
+	}
+	progress := make([]int, len(p.progress))
+	for i := range progress {
+		progress[i] = -1
+	}
+
+	last := len(input) - 1 // position of '\x00'
+	pc, sp := 0, 0
+	for {
+		if b != nil {
+			if err := b.Spend(); err != nil {
+				return nil, err
+			}
+		}
+
+		inst := p.code[pc]
+		switch inst.Opcode {
+		case opcode.Char:
+			if sp == last || input[sp] != inst.C {
+				return nil, nil
+			}
+			pc++
+			sp++
+		case opcode.String:
+			for _, c := range inst.S {
+				if sp == last || input[sp] != c {
+					return nil, nil
+				}
+				sp++
+			}
+			pc++
+		case opcode.ANY:
+			if sp == last {
+				return nil, nil
+			}
+			pc++
+			sp++
+		case opcode.Class:
+			if sp == last || !inst.Class.Contains(input[sp]) {
+				return nil, nil
+			}
+			pc++
+			sp++
+		case opcode.Match:
+			caps[0], caps[1] = 0, sp
+			return caps, nil
+		case opcode.Jmp:
+			pc = p.index[inst.X]
+		case opcode.Split:
+			c := p.choices[pc]
+			switch {
+			case sp == last && c.xEnd, sp < last && c.x.Contains(input[sp]):
+				pc = p.index[inst.X]
+			case sp == last && c.yEnd, sp < last && c.y.Contains(input[sp]):
+				pc = p.index[inst.Y]
+			default:
+				return nil, nil
+			}
+		case opcode.Begin:
+			if sp != 0 {
+				return nil, nil
+			}
+			pc++
+		case opcode.End:
+			if sp != last {
+				return nil, nil
+			}
+			pc++
+		case opcode.Progress:
+			k := p.progress[pc]
+			if progress[k] == sp {
+				return nil, nil
+			}
+			progress[k] = sp
+			pc++
+		case opcode.Save:
+			caps[inst.N] = sp
+			pc++
+		case opcode.NOP:
+			pc++
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package onepass
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// randomPattern returns a random regular expression nested up to depth.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", "c", ".", "[ab]", "[^a]", "()", "$"}
+	if depth == 0 || rnd.Intn(4) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(4) {
+	case 0:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 1:
+		return "(" + randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1) + ")"
+	case 2:
+		return "(" + randomPattern(rnd, depth-1) + ")"
+	}
+	quantifiers := []string{"*", "+", "?", "{2}"}
+	return "(?:" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+}
+
+// compile returns the program of the regular expression, as well as vmregex.CompileWithOptions.
+func compile(t *testing.T, re string) *bytecode.BC {
+	ast, err := parser.NewParser(re).Parse()
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	bc := node.Simplify(ast).Compile()
+	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+	bc.Optimize()
+	return bc
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		re      string
+		onePass bool
+	}{
+		{`^abc`, true},
+		{`^(a|b)c*$`, true},
+		{`^x*$`, true},
+		{`^a?$`, true},
+		{`^(a+)(b+)$`, true},
+		{`^[^,]*,(.*)$`, true},
+		{`abc`, false},       // not anchored
+		{`(?m)^abc`, false},  // anchored at the beginning of a line
+		{`^a*a`, false},      // 'a' can be read by both branches
+		{`^(a|ab)`, false},   // same as above
+		{`^a?`, false},       // whether to stop or to read 'a' can't be told
+		{`^a*(?m:$)`, false}, // the end of a line depends on the characters around
+		{`^(?:a|b)*b$`, false},
+	}
+	for _, tt := range tests {
+		_, err := New(compile(t, tt.re))
+		switch {
+		case tt.onePass && err != nil:
+			t.Errorf("New(%q) = %v, want one-pass", tt.re, err)
+		case !tt.onePass && err != ErrNotOnePass:
+			t.Errorf("New(%q) = %v, want ErrNotOnePass", tt.re, err)
+		}
+	}
+}
+
+// The one-pass executor must record the same positions in the capture slots as VM.
+func TestMatch(t *testing.T) {
+	patterns := []string{`^(a|b)c*$`, `^(a+)(b+)$`, `^[^,]*,(.*)$`, `^(a)?(?:b(c))?$`, `^((a)|(b))*$`}
+	inputs := []string{"", "a", "b", "ac", "bccc", "aab", "aabbc", "x,y,z", ",", "abc", "bc", "abab"}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		patterns = append(patterns, "^"+randomPattern(rnd, 4))
+	}
+	for i := 0; i < 20; i++ {
+		s := make([]rune, rnd.Intn(6))
+		for j := range s {
+			s[j] = []rune("abc")[rnd.Intn(3)]
+		}
+		inputs = append(inputs, string(s))
+	}
+
+	tested := 0
+	for _, re := range patterns {
+		bc := compile(t, re)
+		p, err := New(bc)
+		if err != nil {
+			continue
+		}
+		tested++
+		v, err := vm.NewVM(bc)
+		if err != nil {
+			t.Fatalf("%q: %v", re, err)
+		}
+		for _, s := range inputs {
+			input := append([]rune(s), '\x00')
+			want, err := v.SearchSubmatch(input, nil)
+			if err != nil {
+				t.Fatalf("%q on %q: %v", re, s, err)
+			}
+			got, err := p.Match(input, nil)
+			if err != nil {
+				t.Fatalf("%q on %q: %v", re, s, err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%q on %q: OnePass = %v, VM = %v", re, s, got, want)
+			}
+		}
+	}
+	if tested < 100 {
+		t.Errorf("only %d patterns are one-pass", tested)
+	}
+}
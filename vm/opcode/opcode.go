@@ -8,6 +8,8 @@ func (op Opcode) String() string {
 	switch op {
 	case Char:
 		return "Char"
+	case String:
+		return "String"
 	case Match:
 		return "Match"
 	case Jmp:
@@ -16,6 +18,16 @@ func (op Opcode) String() string {
 		return "Split"
 	case ANY:
 		return "ANY"
+	case Class:
+		return "Class"
+	case Begin:
+		return "Begin"
+	case End:
+		return "End"
+	case Progress:
+		return "Progress"
+	case Save:
+		return "Save"
 	case NOP:
 		return "NOP"
 	}
@@ -28,5 +40,11 @@ const (
 	Jmp
 	Split
 	ANY
+	Class
+	Begin
+	End
+	Progress
+	Save
+	String
 	NOP
 )
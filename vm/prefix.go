@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// prefix has what any match must start with, which is found by analyzing the bytecode.
+// The VM skips the positions where no match can start, instead of running there.
+type prefix struct {
+	lit      []rune          // literal which any match starts with
+	fail     []int           // failure function of lit to look for it (see indexRunes)
+	first    charclass.Class // characters which any match starts with, unless it starts at the beginning of the text
+	begin    bool            // whether a match can start at the beginning of the text (by Begin)
+	anywhere bool            // whether a match can start at any position (nothing to skip)
+}
+
+// newPrefix returns the prefix analyzed from the argument bytecode.
+// The slices x and y have the positions of the targets of the jumps (see VM).
+func newPrefix(bc *bytecode.BC, x, y []int) *prefix {
+	p := &prefix{}
+	prog := bc.Code
+
+	// The literal is the chain of Char (and String) instructions from the top,
+	// which every thread runs before the first Split.
+	seen := map[int]bool{}
+	for pc := 0; pc < bc.N && !seen[pc]; {
+		seen[pc] = true
+		switch prog[pc].Opcode {
+		case opcode.Char:
+			p.lit = append(p.lit, prog[pc].C)
+			pc++
+			continue
+		case opcode.String:
+			p.lit = append(p.lit, prog[pc].S...)
+			pc++
+			continue
+		case opcode.Jmp:
+			pc = x[pc]
+			continue
+		case opcode.Save, opcode.Progress, opcode.NOP:
+			pc++
+			continue
+		}
+		break
+	}
+
+	p.fail = failure(p.lit)
+
+	// The first characters are the ones read by the instructions reachable
+	// from the top without reading anything.
+	seen = map[int]bool{}
+	var visit func(pc int)
+	visit = func(pc int) {
+		if pc >= bc.N || seen[pc] || p.anywhere {
+			return
+		}
+		seen[pc] = true
+
+		switch inst := prog[pc]; inst.Opcode {
+		case opcode.Char:
+			p.first = p.first.Union(charclass.New(charclass.Range{Lo: inst.C, Hi: inst.C}))
+		case opcode.String:
+			p.first = p.first.Union(charclass.New(charclass.Range{Lo: inst.S[0], Hi: inst.S[0]}))
+		case opcode.Class:
+			p.first = p.first.Union(inst.Class)
+		case opcode.Begin:
+			if inst.Class == nil {
+				// The threads passing here can start only at the beginning of the text.
+				p.begin = true
+				return
+			}
+			visit(pc + 1)
+		case opcode.Jmp:
+			visit(x[pc])
+		case opcode.Split:
+			visit(x[pc])
+			visit(y[pc])
+		case opcode.Save, opcode.Progress, opcode.NOP:
+			visit(pc + 1)
+		default:
+			// ANY reads any character, and Match and End can be reached
+			// without reading anything (e.g. the empty string at the end).
+			p.anywhere = true
+		}
+	}
+	visit(0)
+	return p
+}
+
+// next returns the first position from start in the input runes where a match can start,
+// or -1 if no match can start after that. The input must be terminated by '\x00'.
+func (p *prefix) next(input []rune, start int) int {
+	if start >= len(input) {
+		return -1
+	}
+	if p.anywhere || p.begin && start == 0 {
+		return start
+	}
+
+	text := input[:len(input)-1]
+	if len(p.lit) > 0 {
+		return indexRunes(text, p.lit, p.fail, start)
+	}
+	for sp := start; sp < len(text); sp++ {
+		if p.first.Contains(text[sp]) {
+			return sp
+		}
+	}
+	return -1
+}
+
+// failure returns the failure function of lit for the Knuth-Morris-Pratt algorithm,
+// that is, fail[i] is the length of the longest proper prefix of lit[:i+1]
+// which is also its suffix.
+func failure(lit []rune) []int {
+	fail := make([]int, len(lit))
+	for i, k := 1, 0; i < len(lit); i++ {
+		for k > 0 && lit[i] != lit[k] {
+			k = fail[k-1]
+		}
+		if lit[i] == lit[k] {
+			k++
+		}
+		fail[i] = k
+	}
+	return fail
+}
+
+// indexRunes returns the first position from start in the text where lit appears,
+// or -1 if lit doesn't appear. The argument fail is the failure function of lit,
+// so that each rune of the text is compared only a constant number of times
+// on average, however lit partially matches there. (e.g. "aaab" in "aaaaaaab")
+func indexRunes(text, lit []rune, fail []int, start int) int {
+	k := 0 // length of the prefix of lit matched so far
+	for sp := start; sp < len(text); sp++ {
+		for k > 0 && text[sp] != lit[k] {
+			k = fail[k-1]
+		}
+		if text[sp] == lit[k] {
+			k++
+		}
+		if k == len(lit) {
+			return sp + 1 - len(lit)
+		}
+	}
+	return -1
+}
@@ -0,0 +1,26 @@
+package vm
+
+import "testing"
+
+func TestIndexRunes(t *testing.T) {
+	tests := []struct {
+		text, lit string
+		start     int
+		want      int
+	}{
+		{"abc", "abc", 0, 0},
+		{"xabcabc", "abc", 2, 4},
+		{"aaaaaaab", "aaab", 0, 4},
+		{"abababc", "ababc", 0, 2},
+		{"ababab", "abc", 0, -1},
+		{"ab", "abc", 0, -1},
+		{"abc", "c", 3, -1},
+		{"あいあいう", "あいう", 0, 2},
+	}
+	for _, tt := range tests {
+		lit := []rune(tt.lit)
+		if got := indexRunes([]rune(tt.text), lit, failure(lit), tt.start); got != tt.want {
+			t.Errorf("indexRunes(%q, %q, %d) = %d, want %d", tt.text, tt.lit, tt.start, got, tt.want)
+		}
+	}
+}
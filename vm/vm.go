@@ -3,26 +3,83 @@
 package vm
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
 	"github.com/8ayac/vm-regex-engine/vm/instruction"
 	"github.com/8ayac/vm-regex-engine/vm/opcode"
 )
 
+// DefaultMaxThreads is the default capacity of the stack of threads waiting to run.
+const DefaultMaxThreads = 10000
+
+// ErrThreadOverflow is returned when the stack of threads waiting to run exceeds its capacity.
+var ErrThreadOverflow = errors.New("the thread in VM overflowed")
+
 // VM represents a Virtual Machine which executes regular expression matching.
 // The VM has a bytecode and one or more threads.
 type VM struct {
-	bc      bytecode.BC
-	threads []*Thread
+	bc         bytecode.BC
+	threads    []*Thread
+	longest    bool        // leftmost-longest (or leftmost-first) matching
+	reverse    bool        // whether the VM reads the input from right to left
+	maxThreads int         // capacity of the stack of threads
+	stacks     sync.Pool   // stacks of threads reused across the runs (*[]Thread)
+	progress   map[int]int // index of the record in Thread.Progress for each Progress instruction
+	ncap       int         // number of the capture slots (2 for the whole match and 2 for each group)
+	x, y       []int       // positions of the targets X and Y of each Jmp and Split instruction
+	prefix     *prefix     // what any match starts with
 }
 
 // NewVM returns a new VM for executing argument bytecode.
-func NewVM(bc *bytecode.BC) *VM {
+// If the bytecode doesn't pass bytecode.Verify, it returns the error instead.
+// The argument bytecode is not modified.
+func NewVM(bc *bytecode.BC) (*VM, error) {
+	if err := bytecode.Verify(bc); err != nil {
+		return nil, err
+	}
+	code := make([]*instruction.Inst, bc.N, bc.N+1)
+	copy(code, bc.Code)
+	bc = &bytecode.BC{N: bc.N, Code: code}
 	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
 
-	return &VM{
-		bc:      *bc,
-		threads: []*Thread{},
+	// Resolve the targets of the jumps once here, instead of searching
+	// the bytecode for them on each jump.
+	index := make(map[*instruction.Inst]int, bc.N)
+	for i, inst := range bc.Code {
+		index[inst] = i
+	}
+
+	progress := map[int]int{}
+	ncap := 2
+	x, y := make([]int, bc.N), make([]int, bc.N)
+	for i, inst := range bc.Code {
+		switch inst.Opcode {
+		case opcode.Progress:
+			progress[i] = len(progress)
+		case opcode.Save:
+			if inst.N >= ncap {
+				ncap = inst.N + 1
+			}
+		case opcode.Jmp:
+			x[i] = index[inst.X]
+		case opcode.Split:
+			x[i], y[i] = index[inst.X], index[inst.Y]
+		}
 	}
+
+	return &VM{
+		bc:         *bc,
+		threads:    []*Thread{},
+		maxThreads: DefaultMaxThreads,
+		progress:   progress,
+		ncap:       ncap,
+		x:          x,
+		y:          y,
+		prefix:     newPrefix(bc, x, y),
+	}, nil
 }
 
 // AddThread adds a new Thread to the stack of threads in VM.
@@ -30,69 +87,364 @@ func (v *VM) AddThread(t *Thread) {
 	v.threads = append(v.threads, t)
 }
 
-// Run starts to execute regular expression matching for the input string.
+// SetLongest sets whether the VM prefers the longest match among the matches
+// from the same position (leftmost-longest), instead of the first one found
+// by following the priority of Split (leftmost-first).
+func (v *VM) SetLongest(longest bool) {
+	v.longest = longest
+}
+
+// SetReverse sets whether the VM reads the input from right to left.
+// The reversed VM runs the bytecode compiled from the reversed AST (see node.CompileReverse),
+// and the thread reads the rune before the SP and moves the SP to the left.
+// The longest match of the reversed VM is the one which starts at the leftmost position.
+func (v *VM) SetReverse(reverse bool) {
+	v.reverse = reverse
+}
+
+// SetMaxThreads sets the capacity of the stack of threads waiting to run.
+// The stack grows as needed up to the capacity.
+func (v *VM) SetMaxThreads(n int) {
+	v.maxThreads = n
+}
+
+// getStack returns an empty stack of threads, which may be reused from the previous runs.
+func (v *VM) getStack() *[]Thread {
+	if s, ok := v.stacks.Get().(*[]Thread); ok {
+		*s = (*s)[:0]
+		return s
+	}
+	return &[]Thread{}
+}
+
+// Run starts to execute regular expression matching for the input runes
+// from the position start. The input must be terminated by '\x00', which
+// is never matched as a character.
 // If the matching was success the return value would be the matched position
-// in the string. The matched position is the number of characters from
-// the top of matched string to the end.
-func (v *VM) Run(input string) int {
-	const MAXTHREAD = 10000
+// in the input, that is the index of the rune next to the matched string.
+func (v *VM) Run(input []rune, start int) int {
+	sp, _ := v.RunWithBudget(input, start, nil)
+	return sp
+}
 
+// RunWithBudget is the same as Run, but stops the matching when the budget b has run out
+// (or its context is done), or when the stack of threads overflows, and then returns -1
+// and the error. If b is nil, the matching is not limited by the budget.
+func (v *VM) RunWithBudget(input []rune, start int, b *Budget) (int, error) {
+	return v.run(input, start, b, v.newVisited(input), nil)
+}
+
+// Search executes regular expression matching from each position of the input runes
+// in order, until the matching succeeds. The input must be terminated by '\x00'.
+// The positions where no match can start (e.g. the ones which don't start with
+// the literal prefix of the regular expression) are skipped.
+// If the matching was success the return values would be the position where the
+// matched string starts and the position next to its end, otherwise both are -1.
+// As well as RunWithBudget, Search stops the matching with the error.
+func (v *VM) Search(input []rune, b *Budget) (start, end int, err error) {
+	// The states visited from the former positions can be skipped in the latter runs,
+	// because they have already failed to reach Match.
+	visited := v.newVisited(input)
+	for start = v.prefix.next(input, 0); start != -1; start = v.prefix.next(input, start+1) {
+		end, err = v.run(input, start, b, visited, nil)
+		if err != nil || end != -1 {
+			return
+		}
+	}
+	return -1, -1, nil
+}
+
+// SearchSubmatch is the same as Search, but returns the positions recorded in the capture
+// slots by the matched thread. The slots 0 and 1 are the start and the end of the matched
+// string, and the slots 2*i and 2*i+1 are the ones of the string matched by the i-th group
+// (-1 if the group didn't match). If the matching failed, the return value would be nil.
+func (v *VM) SearchSubmatch(input []rune, b *Budget) ([]int, error) {
+	caps := make([]int, v.ncap)
+	visited := v.newVisited(input)
+	for start := v.prefix.next(input, 0); start != -1; start = v.prefix.next(input, start+1) {
+		end, err := v.run(input, start, b, visited, caps)
+		if err != nil {
+			return nil, err
+		}
+		if end != -1 {
+			caps[0], caps[1] = start, end
+			return caps, nil
+		}
+	}
+	return nil, nil
+}
+
+// SearchStart executes regular expression matching with the reversed VM from each
+// of the positions ends in the input runes, and returns the leftmost position where
+// a matched string ending at any of them starts, or -1 if no string matches.
+// The input must be terminated by '\x00'. As well as RunWithBudget,
+// SearchStart stops the matching with the error.
+func (v *VM) SearchStart(input []rune, ends []int, b *Budget) (int, error) {
+	// The states visited from the former positions can be skipped in the latter runs,
+	// because the matches found from there have already been taken into account.
+	visited := v.newVisited(input)
+	start := -1
+	for _, end := range ends {
+		sp, err := v.run(input, end, b, visited, nil)
+		if err != nil {
+			return -1, err
+		}
+		if sp != -1 && (start == -1 || sp < start) {
+			start = sp
+		}
+	}
+	return start, nil
+}
+
+// visitedPageSize is the number of states (pairs of PC and SP) in a page of the bitset
+// to memorize the visited states. The pages are allocated when the states in them are
+// visited first, so the memory grows with the states actually visited, not with the
+// size of the program and the input.
+const visitedPageSize = 256 * 1024
+
+// newVisited returns a new bitset to memorize the visited states in the matching for the input.
+func (v *VM) newVisited(input []rune) *bitset {
+	n := v.bc.N * len(input)
+	return &bitset{
+		pages: make([][]uint64, (n+visitedPageSize-1)/visitedPageSize),
+		size:  n,
+	}
+}
+
+// run executes regular expression matching for the input runes from the position start.
+// Each state (a pair of PC and SP) is explored at most once: a thread reaching a visited
+// state is killed, even if it has recorded the different positions in the capture slots
+// or in the records of Progress. The memo is a part of the matching semantics, not only
+// an optimization, because it decides which thread takes an empty iteration of a loop:
+// the first thread reaching the state wins, as in the backtracking and the Pike VM of
+// Go's regexp package. (e.g. '(|a)+' matches "" at the top of "a", since the thread
+// entering the second iteration without consuming 'a' is killed.)
+// The Progress instructions kill only the threads which the memo would kill anyway,
+// so that the loops end even if visited is nil. (It is nil only in the tests.)
+// If caps is not nil, the threads record the positions in the capture slots,
+// and the ones of the matched thread are copied to caps.
+func (v *VM) run(input []rune, start int, b *Budget, visited *bitset, caps []int) (int, error) {
 	prog := v.bc.Code
-	ready := [MAXTHREAD]*Thread{}
-	nready := 0
+	stack := v.getStack()
+	first := NewThread(0, start)
+	if len(v.progress) > 0 {
+		first.Progress = make([]int, len(v.progress))
+		for i := range first.Progress {
+			first.Progress[i] = -1
+		}
+	}
+	if caps != nil {
+		first.Caps = make([]int, len(caps))
+		for i := range first.Caps {
+			first.Caps[i] = -1
+		}
+	}
+	ready := append(*stack, *first)
+	defer func() {
+		// Keep the grown stack to reuse it in the next run.
+		*stack = ready
+		v.stacks.Put(stack)
+	}()
 
 	var pc int
 	var sp int
+	var progress []int
+	var captured []int
 
-	ready[0] = NewThread(pc, sp)
-	nready = 1
+	matched := -1
 
-	for nready > 0 {
-		nready--
-
-		pc = ready[nready].PC
-		sp = ready[nready].SP
+	for len(ready) > 0 {
+		pc = ready[len(ready)-1].PC
+		sp = ready[len(ready)-1].SP
+		progress = ready[len(ready)-1].Progress
+		captured = ready[len(ready)-1].Caps
+		ready = ready[:len(ready)-1]
 
 		for {
+			if b != nil {
+				if err := b.Spend(); err != nil {
+					return -1, err
+				}
+			}
+			if visited != nil && visited.testAndSet(pc*len(input)+sp) {
+				goto Dead
+			}
+
 			switch prog[pc].Opcode {
 			case opcode.Char:
-				if []rune(input)[sp] != prog[pc].C {
+				r, next, ok := v.read(input, sp)
+				if !ok || r != prog[pc].C {
 					goto Dead
 				}
-				sp++
+				sp = next
+				pc++
+			case opcode.String:
+				for _, c := range prog[pc].S {
+					r, next, ok := v.read(input, sp)
+					if !ok || r != c {
+						goto Dead
+					}
+					sp = next
+				}
 				pc++
 			case opcode.Match:
-				return sp
+				if !v.longest {
+					copy(caps, captured)
+					return sp, nil
+				}
+				if matched == -1 || v.longer(sp, matched) {
+					matched = sp
+					copy(caps, captured)
+				}
+				goto Dead
 			case opcode.Jmp:
-				pc = v.bc.IndexOf(prog[pc].X)
+				pc = v.x[pc]
 			case opcode.Split:
-				if nready >= MAXTHREAD {
-					panic("The thread in VM overflowed!")
+				if len(ready) >= v.maxThreads {
+					return -1, ErrThreadOverflow
+				}
+				t := NewThread(v.y[pc], sp)
+				if progress != nil {
+					t.Progress = append([]int(nil), progress...)
+				}
+				if captured != nil {
+					t.Caps = append([]int(nil), captured...)
 				}
-				ready[nready] = NewThread(v.bc.IndexOf(prog[pc].Y), sp)
-				nready++
-				pc = v.bc.IndexOf(prog[pc].X)
+				ready = append(ready, *t)
+				pc = v.x[pc]
 			case opcode.ANY:
-				if []rune(input)[sp] == '\x00' {
+				_, next, ok := v.read(input, sp)
+				if !ok {
+					goto Dead
+				}
+				sp = next
+				pc++
+			case opcode.Class:
+				r, next, ok := v.read(input, sp)
+				if !ok || !prog[pc].Class.Contains(r) {
 					goto Dead
 				}
+				sp = next
+				pc++
+			case opcode.Begin:
+				if !atBegin(input, sp, prog[pc].Class) {
+					goto Dead
+				}
+				pc++
+			case opcode.End:
+				if !atEnd(input, sp, prog[pc].Class) {
+					goto Dead
+				}
+				pc++
+			case opcode.Progress:
+				k := v.progress[pc]
+				if progress[k] == sp {
+					goto Dead
+				}
+				progress[k] = sp
+				pc++
+			case opcode.Save:
+				if captured != nil {
+					captured[prog[pc].N] = sp
+				}
 				pc++
-				sp++
 			case opcode.NOP:
 				pc++
 			}
 		}
 	Dead:
 	}
-	return -1
+	return matched, nil
+}
+
+// read returns the rune which the thread at the position sp reads next, and the position
+// after reading it. If the thread is at the edge of the input, it returns false.
+func (v *VM) read(input []rune, sp int) (rune, int, bool) {
+	if v.reverse {
+		if sp == 0 {
+			return 0, sp, false
+		}
+		return input[sp-1], sp - 1, true
+	}
+	if sp == len(input)-1 {
+		return 0, sp, false
+	}
+	return input[sp], sp + 1, true
+}
+
+// longer returns whether the match ending at the position sp is longer than
+// the one ending at the position matched.
+func (v *VM) longer(sp, matched int) bool {
+	if v.reverse {
+		return sp < matched
+	}
+	return sp > matched
+}
+
+// atBegin returns whether the position sp in the input is the beginning of the text,
+// or the beginning of a line if the line terminators lt is not nil.
+// If lt has both '\r' and '\n', the position between "\r\n" is not the beginning of a line.
+func atBegin(input []rune, sp int, lt charclass.Class) bool {
+	if sp == 0 {
+		return true
+	}
+	if lt == nil || !lt.Contains(input[sp-1]) {
+		return false
+	}
+	return !(input[sp-1] == '\r' && input[sp] == '\n' && lt.Contains('\n'))
+}
+
+// atEnd returns whether the position sp in the input is the end of the text,
+// or the end of a line if the line terminators lt is not nil.
+// If lt has both '\r' and '\n', the position between "\r\n" is not the end of a line.
+func atEnd(input []rune, sp int, lt charclass.Class) bool {
+	if sp == len(input)-1 {
+		return true
+	}
+	if lt == nil || !lt.Contains(input[sp]) {
+		return false
+	}
+	return !(input[sp] == '\n' && sp > 0 && input[sp-1] == '\r' && lt.Contains('\r'))
+}
+
+// bitset is a set of the non-negative integers less than its size,
+// which are stored in the pages of visitedPageSize bits.
+type bitset struct {
+	pages [][]uint64
+	size  int
+}
+
+// testAndSet adds i to the set, and returns whether i was already in the set.
+func (s *bitset) testAndSet(i int) bool {
+	p := i / visitedPageSize
+	page := s.pages[p]
+	if page == nil {
+		n := s.size - p*visitedPageSize
+		if n > visitedPageSize {
+			n = visitedPageSize
+		}
+		page = make([]uint64, (n+63)/64)
+		s.pages[p] = page
+	}
+	i -= p * visitedPageSize
+	w, m := i/64, uint64(1)<<uint(i%64)
+	if page[w]&m != 0 {
+		return true
+	}
+	page[w] |= m
+	return false
 }
 
 // Thread represents a thread which has two pointers(program counter/string pointer).
 // A program counter (PC) is a register has the information where a instruction which being executed by VM.
 // A string pointer (SP) is a register has the information where a character that the VM is looking at.
+// A thread also has the records of the SP when it passed each Progress instruction last time,
+// and the positions recorded in the capture slots by Save instructions.
 type Thread struct {
-	PC int
-	SP int
+	PC       int
+	SP       int
+	Progress []int
+	Caps     []int
 }
 
 // NewThread returns a new Thread which has the PC and SP set to the value specified by the argument.
@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// randomPattern returns a random regular expression over 'a' and 'b' nested up to depth.
+func randomPattern(rnd *rand.Rand, depth int) string {
+	atoms := []string{"a", "b", ".", "[ab]", "^", "$", "()", "(?:)"}
+	if depth == 0 || rnd.Intn(3) == 0 {
+		return atoms[rnd.Intn(len(atoms))]
+	}
+	switch rnd.Intn(4) {
+	case 0:
+		return randomPattern(rnd, depth-1) + randomPattern(rnd, depth-1)
+	case 1:
+		return randomPattern(rnd, depth-1) + "|" + randomPattern(rnd, depth-1)
+	case 2:
+		return "(" + randomPattern(rnd, depth-1) + ")"
+	}
+	quantifiers := []string{"*", "+", "?", "{0,2}"}
+	return "(?:" + randomPattern(rnd, depth-1) + ")" + quantifiers[rnd.Intn(len(quantifiers))]
+}
+
+// compile returns the program of the regular expression, as well as vmregex.CompileWithOptions.
+func compile(t *testing.T, re string) *bytecode.BC {
+	ast, err := parser.NewParser(re).Parse()
+	if err != nil {
+		t.Fatalf("%q: %v", re, err)
+	}
+	bc := node.Simplify(ast).Compile()
+	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+	bc.Optimize()
+	return bc
+}
+
+// searchWithoutMemo is the same as SearchSubmatch, but runs the VM without memorizing
+// the visited states, so only the Progress instructions stop the empty loops.
+func searchWithoutMemo(v *VM, input []rune) ([]int, error) {
+	caps := make([]int, v.ncap)
+	b := NewBudget(context.Background(), 1000000, 0)
+	for start := 0; start < len(input); start++ {
+		end, err := v.run(input, start, b, nil, caps)
+		if err != nil {
+			return nil, err
+		}
+		if end != -1 {
+			caps[0], caps[1] = start, end
+			return caps, nil
+		}
+	}
+	return nil, nil
+}
+
+// The memo of the visited states decides which thread takes an empty iteration of a loop
+// (see VM.run), so it can change the positions in the capture slots only if the program
+// has the Progress instructions. Otherwise, the results must be the same as without the memo,
+// and in any case, the memo must not change whether and where the matched string starts.
+func TestSearchMemo(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	inputs := []string{"", "a", "b", "ab", "ba", "aab", "abab", "bbaa"}
+	for i := 0; i < 2000; i++ {
+		re := randomPattern(rnd, 4)
+		bc := compile(t, re)
+		progress := strings.Contains(bc.String(), "Progress")
+		for _, longest := range []bool{false, true} {
+			v, err := NewVM(bc)
+			if err != nil {
+				t.Fatalf("%q: %v", re, err)
+			}
+			v.SetLongest(longest)
+			for _, s := range inputs {
+				input := append([]rune(s), '\x00')
+				got, err := v.SearchSubmatch(input, nil)
+				if err != nil {
+					t.Fatalf("%q on %q: %v", re, s, err)
+				}
+				want, err := searchWithoutMemo(v, input)
+				if err != nil {
+					continue
+				}
+				switch {
+				case (got == nil) != (want == nil):
+					t.Errorf("%q (longest %v) on %q: %v with memo, %v without memo", re, longest, s, got, want)
+				case got == nil:
+				case got[0] != want[0]:
+					t.Errorf("%q (longest %v) on %q: starts at %d with memo, %d without memo", re, longest, s, got[0], want[0])
+				case longest && got[1] != want[1]:
+					t.Errorf("%q (longest %v) on %q: ends at %d with memo, %d without memo", re, longest, s, got[1], want[1])
+				case !progress && fmt.Sprint(got) != fmt.Sprint(want):
+					t.Errorf("%q (longest %v) on %q: %v with memo, %v without memo", re, longest, s, got, want)
+				}
+			}
+		}
+	}
+}
+
+// The visited states are memorized even if the program and the input are larger than
+// a page of the memo, so the search doesn't repeat the exploration from each position.
+func TestSearchLargeInput(t *testing.T) {
+	v, err := NewVM(compile(t, "(a|ab)*(c|bd)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := strings.Repeat("ab", 100000)
+	v.SetMaxThreads(2 * len(s))
+	for _, tt := range []struct {
+		input      string
+		start, end int
+	}{
+		{s + "c", 0, len(s) + 1},
+		{s + "d", 0, len(s) + 1},
+		{s, -1, -1},
+	} {
+		b := NewBudget(context.Background(), 100*len(tt.input), 0)
+		start, end, err := v.Search(append([]rune(tt.input), '\x00'), b)
+		if err != nil || start != tt.start || end != tt.end {
+			t.Errorf("Search(%d runes) = %d, %d, %v, want %d, %d", len(tt.input), start, end, err, tt.start, tt.end)
+		}
+	}
+}
+
+// NewVM must reject the Save instruction beyond bytecode.MaxSlots, instead of
+// allocating the capture slots for it on each search.
+func TestNewVMSlots(t *testing.T) {
+	for _, n := range []int{bytecode.MaxSlots - 1, bytecode.MaxSlots, 2000000000} {
+		bc := bytecode.NewByteCode()
+		bc.AddInst(instruction.NewSaveInst(n), bc.N)
+		bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
+		v, err := NewVM(bc)
+		if ok := n < bytecode.MaxSlots; (err == nil) != ok {
+			t.Errorf("NewVM(Save %d) = %v, want ok %v", n, err, ok)
+			continue
+		}
+		if err == nil && v.ncap != n+1 {
+			t.Errorf("NewVM(Save %d): %d slots, want %d", n, v.ncap, n+1)
+		}
+	}
+}
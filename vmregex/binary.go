@@ -0,0 +1,537 @@
+package vmregex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+	"unicode/utf8"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/charclass"
+	"github.com/8ayac/vm-regex-engine/vm/dfa"
+	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/opcode"
+)
+
+// The binary encoding of Regexp starts with binaryMagic and the version (uint16),
+// which is followed by the payload, and ends with the CRC-32 (IEEE) checksum (uint32)
+// of all the bytes before it. The integers are little-endian, and the ones in
+// the payload are varints.
+//
+// The payload has the regular expression, the options, the table of the character classes,
+// the compiled programs, the number of the groups, the literals, whether the regular
+// expression is anchored at the end, and the table of the DFA for EngineDFA.
+// Each instruction of the programs refers to its jump targets by their positions,
+// and to its character class by its index in the table.
+// The decoding doesn't parse the regular expression nor build the DFA again,
+// but only checks whether the decoded values can be run safely.
+const (
+	binaryMagic   = "VMRE"
+	binaryVersion = 2
+)
+
+// Errors which stop decoding the binary encoding of Regexp.
+var (
+	// ErrBinaryFormat is returned when the data is not the binary encoding of Regexp or broken.
+	ErrBinaryFormat = errors.New("vmregex: invalid binary encoding")
+
+	// ErrBinaryVersion is returned when the data is encoded in the version not supported.
+	ErrBinaryVersion = errors.New("vmregex: unsupported version of binary encoding")
+
+	// ErrBinaryChecksum is returned when the checksum of the data doesn't match.
+	ErrBinaryChecksum = errors.New("vmregex: checksum mismatch of binary encoding")
+)
+
+// Bits of the boolean options in the binary encoding.
+const (
+	binaryCaseInsensitive = 1 << iota
+	binaryMultiLine
+	binaryDotAll
+	binaryFreeSpacing
+	binaryLongest
+)
+
+// MarshalBinary returns the binary encoding of the compiled Regexp.
+// It implements encoding.BinaryMarshaler.
+func (re *Regexp) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.buf = append(e.buf, binaryMagic...)
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, binaryVersion)
+
+	e.string(re.regexp)
+	e.options(re.opts)
+
+	var table []charclass.Class
+	for _, bc := range []*bytecode.BC{re.prog, re.rprog} {
+		if bc != nil {
+			table = classTable(table, bc)
+		}
+	}
+	e.uvarint(uint64(len(table)))
+	for _, c := range table {
+		e.class(c)
+	}
+	e.program(re.prog, table)
+	e.bool(re.rprog != nil)
+	if re.rprog != nil {
+		e.program(re.rprog, table)
+	}
+	e.varint(int64(re.groups))
+	e.literals(re.literals)
+	e.bool(re.endAnchored)
+	e.bool(re.full != nil)
+	if re.full != nil {
+		e.dfa(re.full)
+	}
+
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+	return e.buf, nil
+}
+
+// UnmarshalBinary replaces the Regexp with the one decoded from the binary encoding
+// made by MarshalBinary. The programs and the DFA are not compiled again, but the decoded
+// programs are verified by bytecode.VerifySlots with the slots for the decoded number
+// of the groups (see Regexp.build), and the table of the DFA by dfa.FromTable.
+// It implements encoding.BinaryUnmarshaler.
+func (re *Regexp) UnmarshalBinary(data []byte) error {
+	header := len(binaryMagic) + 2
+	if len(data) < header+4 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return ErrBinaryFormat
+	}
+	if v := binary.LittleEndian.Uint16(data[len(binaryMagic):]); v != binaryVersion {
+		return fmt.Errorf("%w: %d", ErrBinaryVersion, v)
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return ErrBinaryChecksum
+	}
+
+	d := &decoder{buf: body[header:]}
+	r := &Regexp{}
+	r.regexp = d.string()
+	r.opts = d.options()
+
+	table := make([]charclass.Class, d.count())
+	for i := range table {
+		table[i] = d.class()
+	}
+	r.prog = d.program(table)
+	if d.bool() {
+		r.rprog = d.program(table)
+	}
+	r.groups = d.int()
+	r.literals = d.literals()
+	r.endAnchored = d.bool()
+	var full *dfaTable
+	if d.bool() {
+		full = d.dfa()
+	}
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = ErrBinaryFormat
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	if err := r.opts.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrBinaryFormat, err)
+	}
+	if r.groups < 0 || 2*(r.groups+1) > bytecode.MaxSlots {
+		return fmt.Errorf("%w: %d groups", ErrBinaryFormat, r.groups)
+	}
+	if (r.opts.Engine == EngineDFA) != (full != nil) {
+		return fmt.Errorf("%w: DFA for engine %d", ErrBinaryFormat, r.opts.Engine)
+	}
+	if full != nil {
+		var err error
+		if r.full, err = dfa.FromTable(full.alphabet, full.table, full.accept, full.start); err != nil {
+			return fmt.Errorf("%w: %v", ErrBinaryFormat, err)
+		}
+	}
+	if err := r.build(); err != nil {
+		return fmt.Errorf("%w: %v", ErrBinaryFormat, err)
+	}
+	*re = *r
+	return nil
+}
+
+// classTable appends the character classes in the bytecode to the table,
+// unless the table has them already.
+func classTable(table []charclass.Class, bc *bytecode.BC) []charclass.Class {
+	for _, inst := range bc.Code {
+		if inst.Class != nil && classIndex(table, inst.Class) < 0 {
+			table = append(table, inst.Class)
+		}
+	}
+	return table
+}
+
+// classIndex returns the index of the character class in the table, or -1 if it is not there.
+func classIndex(table []charclass.Class, c charclass.Class) int {
+	for i, t := range table {
+		if t.Equal(c) {
+			return i
+		}
+	}
+	return -1
+}
+
+// encoder appends the values to the binary encoding.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) options(o Options) {
+	var bits uint64
+	if o.CaseInsensitive {
+		bits |= binaryCaseInsensitive
+	}
+	if o.MultiLine {
+		bits |= binaryMultiLine
+	}
+	if o.DotAll {
+		bits |= binaryDotAll
+	}
+	if o.FreeSpacing {
+		bits |= binaryFreeSpacing
+	}
+	if o.Longest {
+		bits |= binaryLongest
+	}
+	e.uvarint(bits)
+	e.varint(int64(o.LineTerminator))
+	e.varint(int64(o.Engine))
+	e.varint(int64(o.Dialect))
+	e.varint(int64(o.MaxProgramSize))
+	e.varint(int64(o.MaxRepeat))
+	e.varint(int64(o.MaxSteps))
+	e.varint(int64(o.Timeout))
+	e.varint(int64(o.MaxThreads))
+	e.varint(int64(o.MaxDFAStates))
+}
+
+func (e *encoder) class(c charclass.Class) {
+	e.uvarint(uint64(len(c)))
+	for _, r := range c {
+		e.varint(int64(r.Lo))
+		e.varint(int64(r.Hi))
+	}
+}
+
+func (e *encoder) literals(l *literals) {
+	e.bool(l.exact)
+	e.string(l.lit)
+	e.bool(l.anchor)
+	e.string(l.prefix)
+	e.string(l.suffix)
+	e.uvarint(uint64(len(l.required)))
+	for _, s := range l.required {
+		e.string(s)
+	}
+}
+
+// dfa appends the table of the DFA (see dfa.DFA.Table). The transitions are
+// written for each state in order, as many as the rune classes in the alphabet.
+func (e *encoder) dfa(d *dfa.DFA) {
+	alphabet, table, accept, start := d.Table()
+	e.uvarint(uint64(len(alphabet)))
+	for _, r := range alphabet {
+		e.varint(int64(r))
+	}
+	e.uvarint(uint64(len(accept)))
+	for _, a := range accept {
+		e.bool(a)
+	}
+	for _, s := range table {
+		e.varint(int64(s))
+	}
+	e.varint(int64(start))
+}
+
+// program appends the bytecode. The character classes are written as
+// their indexes in the table plus one, and 0 means nil.
+func (e *encoder) program(bc *bytecode.BC, table []charclass.Class) {
+	index := map[*instruction.Inst]int{}
+	for i, inst := range bc.Code {
+		index[inst] = i
+	}
+
+	e.uvarint(uint64(bc.N))
+	for _, inst := range bc.Code {
+		e.uvarint(uint64(inst.Opcode))
+		switch inst.Opcode {
+		case opcode.Char:
+			e.varint(int64(inst.C))
+		case opcode.Jmp:
+			e.uvarint(uint64(index[inst.X]))
+		case opcode.Split:
+			e.uvarint(uint64(index[inst.X]))
+			e.uvarint(uint64(index[inst.Y]))
+		case opcode.Class, opcode.Begin, opcode.End:
+			if inst.Class == nil {
+				e.uvarint(0)
+			} else {
+				e.uvarint(uint64(classIndex(table, inst.Class) + 1))
+			}
+		case opcode.Save:
+			e.varint(int64(inst.N))
+		case opcode.String:
+			e.uvarint(uint64(len(inst.S)))
+			for _, r := range inst.S {
+				e.varint(int64(r))
+			}
+		}
+	}
+}
+
+// decoder reads the values from the binary encoding. Once an error occurs,
+// the following reads return the zero values and the error is kept.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrBinaryFormat
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = ErrBinaryFormat
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// int reads a varint which must fit in int.
+func (d *decoder) int() int {
+	v := d.varint()
+	if int64(int(v)) != v {
+		d.fail()
+		return 0
+	}
+	return int(v)
+}
+
+// count reads the number of the items, which can't exceed the rest of the data.
+func (d *decoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.buf)) {
+		d.fail()
+		return 0
+	}
+	return int(v)
+}
+
+// rune reads a varint which must be a rune.
+func (d *decoder) rune() rune {
+	v := d.varint()
+	if int64(rune(v)) != v {
+		d.fail()
+		return 0
+	}
+	return rune(v)
+}
+
+func (d *decoder) bool() bool {
+	switch v := d.uvarint(); v {
+	case 0, 1:
+		return v == 1
+	}
+	d.fail()
+	return false
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrBinaryFormat
+	}
+}
+
+func (d *decoder) options() Options {
+	bits := d.uvarint()
+	return Options{
+		CaseInsensitive: bits&binaryCaseInsensitive != 0,
+		MultiLine:       bits&binaryMultiLine != 0,
+		DotAll:          bits&binaryDotAll != 0,
+		FreeSpacing:     bits&binaryFreeSpacing != 0,
+		Longest:         bits&binaryLongest != 0,
+		LineTerminator:  LineTerminator(d.int()),
+		Engine:          Engine(d.int()),
+		Dialect:         Dialect(d.int()),
+		MaxProgramSize:  d.int(),
+		MaxRepeat:       d.int(),
+		MaxSteps:        d.int(),
+		Timeout:         time.Duration(d.varint()),
+		MaxThreads:      d.int(),
+		MaxDFAStates:    d.int(),
+	}
+}
+
+// literals reads the literals written by encoder.literals.
+// Their strings must be valid UTF-8, as the ones found in the regular expression.
+func (d *decoder) literals() *literals {
+	l := &literals{
+		exact:  d.bool(),
+		lit:    d.string(),
+		anchor: d.bool(),
+		prefix: d.string(),
+		suffix: d.string(),
+	}
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		l.required = append(l.required, d.string())
+	}
+	for _, s := range append([]string{l.lit, l.prefix, l.suffix}, l.required...) {
+		if !utf8.ValidString(s) {
+			d.fail()
+		}
+	}
+	return l
+}
+
+// dfaTable is the table of the DFA read by decoder.dfa, which is given to dfa.FromTable.
+type dfaTable struct {
+	alphabet []rune
+	table    []int
+	accept   []bool
+	start    int
+}
+
+// dfa reads the table of the DFA written by encoder.dfa.
+func (d *decoder) dfa() *dfaTable {
+	t := &dfaTable{}
+	k := d.count()
+	for i := 0; i < k && d.err == nil; i++ {
+		t.alphabet = append(t.alphabet, d.rune())
+	}
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		t.accept = append(t.accept, d.bool())
+	}
+	// Each transition takes a byte at least, so the table can't exceed the rest of the data.
+	if k*n > len(d.buf) {
+		d.fail()
+		return nil
+	}
+	t.table = make([]int, k*n)
+	for i := range t.table {
+		t.table[i] = d.int()
+	}
+	t.start = d.int()
+	return t
+}
+
+func (d *decoder) class() charclass.Class {
+	n := d.count()
+	rs := make([]charclass.Range, n)
+	for i := range rs {
+		rs[i] = charclass.Range{Lo: d.rune(), Hi: d.rune()}
+		if rs[i].Lo > rs[i].Hi {
+			d.fail()
+		}
+	}
+	return charclass.New(rs...)
+}
+
+// program reads the bytecode written by encoder.program.
+func (d *decoder) program(table []charclass.Class) *bytecode.BC {
+	n := d.count()
+	code := make([]*instruction.Inst, n)
+	for i := range code {
+		code[i] = &instruction.Inst{}
+	}
+	target := func() *instruction.Inst {
+		i := d.uvarint()
+		if i >= uint64(n) {
+			d.fail()
+			return nil
+		}
+		return code[i]
+	}
+
+	bc := bytecode.NewByteCode()
+	for _, inst := range code {
+		if d.err != nil {
+			return nil
+		}
+		op := d.uvarint()
+		if op > uint64(opcode.NOP) {
+			d.fail()
+			return nil
+		}
+		inst.Opcode = opcode.Opcode(op)
+		switch inst.Opcode {
+		case opcode.Char:
+			inst.C = d.rune()
+		case opcode.Jmp:
+			inst.X = target()
+		case opcode.Split:
+			inst.X = target()
+			inst.Y = target()
+		case opcode.Class, opcode.Begin, opcode.End:
+			i := d.uvarint()
+			if i > uint64(len(table)) {
+				d.fail()
+			} else if i > 0 {
+				inst.Class = table[i-1]
+			}
+		case opcode.Save:
+			inst.N = d.int()
+		case opcode.String:
+			m := d.count()
+			for j := 0; j < m && d.err == nil; j++ {
+				inst.S = append(inst.S, d.rune())
+			}
+		}
+		bc.AddInst(inst, bc.N)
+	}
+	return bc
+}
@@ -0,0 +1,197 @@
+package vmregex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+var binaryTests = []struct {
+	re   string
+	opts Options
+}{
+	{`(a|b)c*`, Options{}},
+	{`^(\w+)@(\w+)\.com$`, Options{}},
+	{`(?i)[^k]+(x)?`, Options{}},
+	{`^error: .*$`, Options{CaseInsensitive: true, MultiLine: true}},
+	{`(a+)(b+)?`, Options{Longest: true}},
+	{`[a-c]+\d`, Options{Engine: EngineDFA}},
+	{`(x|y)*z`, Options{Engine: EngineLazyDFA}},
+	{`\.log$`, Options{}},
+	{`(?x) a b # comment`, Options{}},
+	{`(a){0}(b)`, Options{}},
+	{`[[:alpha:]]+`, Options{Dialect: DialectPOSIX}},
+	{`a.b`, Options{DotAll: true, LineTerminator: LineTerminatorCRLF, MaxSteps: 1000}},
+}
+
+var binaryInputs = []string{"", "a", "acc", "bc", "xyz", "foo@bar.com", "ERROR: x\n", "aabb", "a\nb", "x.log", "ab", "b"}
+
+// The decoded Regexp must be the same as the encoded one.
+func TestMarshalBinary(t *testing.T) {
+	for _, tt := range binaryTests {
+		re, err := CompileWithOptions(tt.re, tt.opts)
+		if err != nil {
+			t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+		}
+		data, err := re.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%q: MarshalBinary: %v", tt.re, err)
+		}
+		got := &Regexp{}
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%q: UnmarshalBinary: %v", tt.re, err)
+		}
+
+		if got.String() != re.String() || got.Options() != re.Options() || got.NumSubexp() != re.NumSubexp() {
+			t.Errorf("%q: decoded as %q %+v with %d groups", tt.re, got.String(), got.Options(), got.NumSubexp())
+		}
+		p1, c1 := re.LiteralPrefix()
+		p2, c2 := got.LiteralPrefix()
+		if p1 != p2 || c1 != c2 || fmt.Sprint(re.RequiredLiterals()) != fmt.Sprint(got.RequiredLiterals()) {
+			t.Errorf("%q: literals %q %v %q, want %q %v %q", tt.re, p2, c2, got.RequiredLiterals(), p1, c1, re.RequiredLiterals())
+		}
+		for _, s := range binaryInputs {
+			if m1, m2 := re.MatchSubmatch(s), got.MatchSubmatch(s); fmt.Sprint(m1) != fmt.Sprint(m2) {
+				t.Errorf("%q on %q: decoded one matches %v, want %v", tt.re, s, m2, m1)
+			}
+		}
+	}
+}
+
+// withChecksum returns the data whose checksum is replaced with the correct one.
+func withChecksum(data []byte) []byte {
+	body := data[:len(data)-4]
+	return binary.LittleEndian.AppendUint32(append([]byte(nil), body...), crc32.ChecksumIEEE(body))
+}
+
+// The broken data must be rejected, or decoded into a Regexp which can run safely.
+func TestUnmarshalBinaryCorrupted(t *testing.T) {
+	for _, tt := range binaryTests {
+		re, err := CompileWithOptions(tt.re, tt.opts)
+		if err != nil {
+			t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+		}
+		data, _ := re.MarshalBinary()
+
+		for n := 0; n < len(data); n++ {
+			if err := (&Regexp{}).UnmarshalBinary(data[:n]); err == nil {
+				t.Errorf("%q: the data truncated to %d bytes is decoded", tt.re, n)
+			}
+		}
+
+		broken := append([]byte(nil), data...)
+		broken[len(broken)/2] ^= 0xff
+		if err := (&Regexp{}).UnmarshalBinary(broken); !errors.Is(err, ErrBinaryChecksum) {
+			t.Errorf("%q: the data with a wrong checksum: %v, want %v", tt.re, err, ErrBinaryChecksum)
+		}
+
+		// The payload altered on purpose has the correct checksum.
+		for i := len(binaryMagic) + 2; i < len(data)-4; i++ {
+			for _, x := range []byte{0x01, 0x80, 0xff} {
+				altered := append([]byte(nil), data...)
+				altered[i] ^= x
+				got := &Regexp{}
+				if err := got.UnmarshalBinary(withChecksum(altered)); err != nil {
+					continue
+				}
+				for _, s := range binaryInputs {
+					got.MatchSubmatch(s)
+					got.Match(s)
+					got.MatchString(s)
+				}
+			}
+		}
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	re := Compile(`(a)(b)`)
+	data, _ := re.MarshalBinary()
+
+	wrongMagic := append([]byte("XXXX"), data[4:]...)
+	if err := (&Regexp{}).UnmarshalBinary(wrongMagic); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("wrong magic: %v, want %v", err, ErrBinaryFormat)
+	}
+	wrongVersion := append([]byte(nil), data...)
+	wrongVersion[len(binaryMagic)]++
+	if err := (&Regexp{}).UnmarshalBinary(withChecksum(wrongVersion)); !errors.Is(err, ErrBinaryVersion) {
+		t.Errorf("wrong version: %v, want %v", err, ErrBinaryVersion)
+	}
+
+	// The program saving to the slots of the groups which the regular expression doesn't have.
+	lessGroups := Compile(`(a)(b)`)
+	lessGroups.groups = 1
+	data, _ = lessGroups.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("program with the slots of 2 groups for 1 group: %v, want %v", err, ErrBinaryFormat)
+	}
+
+	outOfSlots := Compile(`(a)(b)`)
+	for _, inst := range outOfSlots.prog.Code {
+		if inst.N == 5 {
+			inst.N = 1 << 30
+		}
+	}
+	data, _ = outOfSlots.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("program saving to slot %d: %v, want %v", 1<<30, err, ErrBinaryFormat)
+	}
+
+	// The number of the groups decides the size of the result of MatchSubmatch.
+	manyGroups := Compile(`(a)(b)`)
+	manyGroups.groups = 1 << 30
+	data, _ = manyGroups.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("%d groups: %v, want %v", 1<<30, err, ErrBinaryFormat)
+	}
+
+	invalidLiteral := Compile(`(a)(b)`)
+	invalidLiteral.literals.prefix = "\xff"
+	data, _ = invalidLiteral.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("literal in invalid UTF-8: %v, want %v", err, ErrBinaryFormat)
+	}
+
+	noDFA := Compile(`(a)(b)`)
+	noDFA.opts.Engine = EngineDFA
+	data, _ = noDFA.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("EngineDFA without DFA: %v, want %v", err, ErrBinaryFormat)
+	}
+
+	invalidDFA, err := CompileWithOptions(`[a-c]+\d`, Options{Engine: EngineDFA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, table, accept, _ := invalidDFA.full.Table()
+	table[len(table)-1] = len(accept)
+	data, _ = invalidDFA.MarshalBinary()
+	if err := (&Regexp{}).UnmarshalBinary(data); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("DFA with the transition to state %d: %v, want %v", len(accept), err, ErrBinaryFormat)
+	}
+}
+
+// The decoded DFA is not built again, so it is kept even if the options don't allow
+// to build it any longer.
+func TestUnmarshalBinaryDFA(t *testing.T) {
+	re, err := CompileWithOptions(`[a-c]+\d`, Options{Engine: EngineDFA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	re.opts.MaxDFAStates = 1
+	data, _ := re.MarshalBinary()
+	got := &Regexp{}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if got.DFAStateCount() != re.DFAStateCount() {
+		t.Errorf("decoded DFA has %d states, want %d", got.DFAStateCount(), re.DFAStateCount())
+	}
+	for _, s := range []string{"", "a1", "xb2", "abc", "1"} {
+		if got.MatchString(s) != re.MatchString(s) {
+			t.Errorf("decoded DFA on %q: %v, want %v", s, got.MatchString(s), re.MatchString(s))
+		}
+	}
+}
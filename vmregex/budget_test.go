@@ -0,0 +1,56 @@
+package vmregex
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// matchAll runs each of the matching methods with ctx, and returns their errors.
+func matchAll(ctx context.Context, re *Regexp, s string) map[string]error {
+	_, _, err1 := re.MatchContext(ctx, s)
+	_, err2 := re.MatchSubmatchContext(ctx, s)
+	_, err3 := re.MatchStringContext(ctx, s)
+	return map[string]error{"MatchContext": err1, "MatchSubmatchContext": err2, "MatchStringContext": err3}
+}
+
+// The inputs contain the literals required to match, so that the matchings are not
+// skipped by the prefilter. The matched strings span the whole inputs, so that every
+// engine (forward or reversed) really consumes the budget and the capacity of threads.
+func TestMatchLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		re    string
+		opts  Options
+		ctx   context.Context
+		input string
+		err   error
+	}{
+		{`(a|aa)*c`, Options{MaxSteps: 100}, context.Background(), strings.Repeat("a", 1000) + "c", ErrMatchBudgetExceeded},
+		{`(a|aa)*c`, Options{MaxSteps: 100, Engine: EngineBacktrack}, context.Background(), strings.Repeat("a", 1000) + "c", ErrMatchBudgetExceeded},
+		{`(a|aa)*c`, Options{MaxSteps: 100000}, context.Background(), strings.Repeat("a", 1000) + "c", nil},
+		{`x(a|aa)*c`, Options{}, canceled, "x" + strings.Repeat("a", 1000) + "c", context.Canceled},
+		{`x(a|aa)*c`, Options{Engine: EngineBacktrack}, canceled, "x" + strings.Repeat("a", 1000) + "c", context.Canceled},
+		{`x(a|aa)*c`, Options{}, context.Background(), "x" + strings.Repeat("a", 1000) + "c", nil},
+		{`(a)*c`, Options{MaxThreads: 10, Engine: EngineBacktrack}, context.Background(), strings.Repeat("a", 100) + "c", ErrThreadOverflow},
+		{`(a)*c`, Options{MaxThreads: 1000, Engine: EngineBacktrack}, context.Background(), strings.Repeat("a", 100) + "c", nil},
+	}
+	for _, tt := range tests {
+		re, err := CompileWithOptions(tt.re, tt.opts)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.re, err)
+		}
+		for _, lit := range re.RequiredLiterals() {
+			if !strings.Contains(tt.input, lit) {
+				t.Fatalf("%q: the input doesn't contain the required literal %q", tt.re, lit)
+			}
+		}
+		for method, err := range matchAll(tt.ctx, re, tt.input) {
+			if err != tt.err {
+				t.Errorf("%q (%+v): %s = %v, want %v", tt.re, tt.opts, method, err, tt.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package vmregex
+
+import (
+	"regexp"
+	"testing"
+)
+
+// In the case-insensitive mode, the negated classes must be folded before the negation,
+// as well as the ones of Go's regexp package.
+func TestFoldNegatedClass(t *testing.T) {
+	patterns := []string{
+		`(?i)[^a]`, `(?i)[^k]`, `(?i)[^A-Z]`, `(?i)[^\w]`,
+		`(?i)\W`, `(?i)\D`, `(?i)\S`, `(?i)\P{Lu}`, `(?i)\p{^Ll}`,
+		`(?i)[[:^alpha:]]`, `(?i)[[:^upper:]]`, `(?i)[^[:lower:]]`,
+		`(?i)[^\P{Lu}]`, `(?i)[^\W]`,
+	}
+	inputs := []string{"a", "A", "k", "K", "K", "s", "ſ", "1", "_", " ", "é", "É"}
+	for _, p := range patterns {
+		re := Compile(p)
+		g := regexp.MustCompile(p)
+		for _, s := range inputs {
+			if got, want := re.MatchString(s), g.MatchString(s); got != want {
+				t.Errorf("%q on %q: %v, want %v", p, s, got, want)
+			}
+		}
+	}
+}
+
+// The set operations are folded operand by operand, so the difference removes all the cases.
+func TestFoldSetOperation(t *testing.T) {
+	tests := []struct {
+		re    string
+		input string
+		want  bool
+	}{
+		{`(?i)[a-z--k]`, "K", false},
+		{`(?i)[a-z--k]`, "K", false},
+		{`(?i)[a-z--k]`, "L", true},
+		{`(?i)[\w&&[^a-z]]`, "A", false},
+		{`(?i)[\w&&[^a-z]]`, "1", true},
+		{`(?i)[^a-z--k]`, "K", true},
+	}
+	for _, tt := range tests {
+		if got := Compile(tt.re).MatchString(tt.input); got != tt.want {
+			t.Errorf("%q on %q: %v, want %v", tt.re, tt.input, got, tt.want)
+		}
+	}
+}
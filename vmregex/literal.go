@@ -0,0 +1,167 @@
+package vmregex
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/8ayac/vm-regex-engine/node"
+)
+
+// literals has the literal strings found in the strings matched by a subtree of AST.
+type literals struct {
+	exact    bool     // whether the subtree matches only the string lit
+	lit      string   // the only string matched by the subtree (if exact)
+	anchor   bool     // whether the subtree has any anchors
+	prefix   string   // literal which every matched string starts with
+	suffix   string   // literal which every matched string ends with
+	required []string // literals which every matched string contains
+}
+
+// exactLiterals returns the literals of a subtree which matches only the string s.
+func exactLiterals(s string) *literals {
+	return &literals{
+		exact:    true,
+		lit:      s,
+		prefix:   s,
+		suffix:   s,
+		required: []string{s},
+	}
+}
+
+// findLiterals returns the literals found in the strings matched by the AST.
+func findLiterals(nd node.Node) *literals {
+	switch nd := nd.(type) {
+	case *node.Character:
+		return exactLiterals(string(nd.V))
+	case *node.CharClass:
+		if len(nd.C) == 1 && nd.C[0].Lo == nd.C[0].Hi {
+			return exactLiterals(string(nd.C[0].Lo))
+		}
+	case *node.Begin, *node.End:
+		l := exactLiterals("")
+		l.anchor = true
+		return l
+	case *node.Epsilon:
+		return exactLiterals("")
+	case *node.Group:
+		return findLiterals(nd.Ope)
+	case *node.Concat:
+		return concatLiterals(findLiterals(nd.Ope1), findLiterals(nd.Ope2))
+	case *node.Union:
+		return unionLiterals(findLiterals(nd.Ope1), findLiterals(nd.Ope2))
+	case *node.Plus:
+		return repeatedLiterals(findLiterals(nd.Ope))
+	case *node.Repeat:
+		if nd.Min > 0 {
+			return repeatedLiterals(findLiterals(nd.Ope))
+		}
+		return &literals{anchor: findLiterals(nd.Ope).anchor}
+	case *node.Star:
+		return &literals{anchor: findLiterals(nd.Ope).anchor}
+	case *node.Question:
+		return &literals{anchor: findLiterals(nd.Ope).anchor}
+	}
+	return &literals{}
+}
+
+// concatLiterals returns the literals of the concatenation of the subtrees with l1 and l2.
+func concatLiterals(l1, l2 *literals) *literals {
+	if l1.exact && l2.exact {
+		l := exactLiterals(l1.lit + l2.lit)
+		l.anchor = l1.anchor || l2.anchor
+		return l
+	}
+
+	l := &literals{
+		anchor:   l1.anchor || l2.anchor,
+		prefix:   l1.prefix,
+		suffix:   l2.suffix,
+		required: append(append([]string{l1.suffix + l2.prefix}, l1.required...), l2.required...),
+	}
+	if l1.exact {
+		l.prefix = l1.lit + l2.prefix
+	}
+	if l2.exact {
+		l.suffix = l1.suffix + l2.lit
+	}
+	return l
+}
+
+// unionLiterals returns the literals of the union of the subtrees with l1 and l2.
+func unionLiterals(l1, l2 *literals) *literals {
+	if l1.exact && l2.exact && l1.lit == l2.lit {
+		return l1
+	}
+
+	l := &literals{
+		anchor: l1.anchor || l2.anchor,
+		prefix: commonPrefix(l1.prefix, l2.prefix),
+		suffix: commonSuffix(l1.suffix, l2.suffix),
+	}
+	for _, s1 := range l1.required {
+		for _, s2 := range l2.required {
+			if s1 == s2 {
+				l.required = append(l.required, s1)
+			}
+		}
+	}
+	return l
+}
+
+// repeatedLiterals returns the literals of the repetition (once or more)
+// of the subtree with l.
+func repeatedLiterals(l *literals) *literals {
+	return &literals{
+		anchor:   l.anchor,
+		prefix:   l.prefix,
+		suffix:   l.suffix,
+		required: l.required,
+	}
+}
+
+// commonPrefix returns the longest common prefix of the strings s1 and s2.
+func commonPrefix(s1, s2 string) string {
+	r1, r2 := []rune(s1), []rune(s2)
+	i := 0
+	for i < len(r1) && i < len(r2) && r1[i] == r2[i] {
+		i++
+	}
+	return string(r1[:i])
+}
+
+// commonSuffix returns the longest common suffix of the strings s1 and s2.
+func commonSuffix(s1, s2 string) string {
+	r1, r2 := []rune(s1), []rune(s2)
+	i := 0
+	for i < len(r1) && i < len(r2) && r1[len(r1)-1-i] == r2[len(r2)-1-i] {
+		i++
+	}
+	return string(r1[len(r1)-i:])
+}
+
+// requiredLiterals returns the literals which every string matched by the subtree
+// with l contains, except the ones contained in the others. The longer ones come first.
+func requiredLiterals(l *literals) []string {
+	all := append([]string{l.prefix, l.suffix}, l.required...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return len(all[i]) > len(all[j])
+	})
+
+	var required []string
+	for _, s := range all {
+		if s == "" {
+			continue
+		}
+		redundant := false
+		for _, r := range required {
+			if strings.Contains(r, s) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			required = append(required, s)
+		}
+	}
+	return required
+}
@@ -0,0 +1,53 @@
+package vmregex
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+// The loops whose operand can match the empty string must match the same strings
+// (and capture the same positions) as the ones of Go's regexp package.
+func TestEmptyLoop(t *testing.T) {
+	tests := []struct {
+		re    string
+		input string
+	}{
+		{`($)+`, "x"},
+		{`(^)+a`, "ba"},
+		{`(|a)+`, "a"},
+		{`(|a)*`, "a"},
+		{`(|a)+b`, "aab"},
+		{`((?:)|.)*`, "Abx11Aa"},
+		{`((a)?)+`, ""},
+		{`((a)?)+`, "b"},
+		{`((a)?)+`, "a"},
+		{`(a*)+`, "aab"},
+		{`(a*)*`, "b"},
+		{`(a*|b)*`, "abab"},
+		{`(?:b|(?:|a))+`, "ba"},
+		{`(?:(?:b|)(?:|c))+`, "bc"},
+		{`(?:(a*)(b*))*c`, "abbac"},
+		{`(^|x)+`, "xx"},
+		{`(?m)(^|a)+$`, "b\naa"},
+	}
+	for _, tt := range tests {
+		want := regexp.MustCompile(tt.re).FindStringSubmatchIndex(tt.input)
+		for _, engine := range []Engine{EngineAuto, EngineBacktrack, EngineLazyDFA, EngineDFA} {
+			re, err := CompileWithOptions(tt.re, Options{Engine: engine})
+			if err != nil && engine != EngineBacktrack {
+				// The DFA can't run the bytecode with the assertions.
+				continue
+			}
+			if err != nil {
+				t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+			}
+			if got := re.MatchSubmatch(tt.input); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%q (engine %d) on %q: MatchSubmatch = %v, want %v", tt.re, engine, tt.input, got, want)
+			}
+			if got := re.MatchString(tt.input); got != (want != nil) {
+				t.Errorf("%q (engine %d) on %q: MatchString = %v, want %v", tt.re, engine, tt.input, got, want != nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package vmregex
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/8ayac/vm-regex-engine/lexer"
+	"github.com/8ayac/vm-regex-engine/node"
+)
+
+// Engine is integer to identify the executor which runs the compiled program.
+type Engine int
+
+// Each engine is identified by a unique integer.
+const (
+	EngineAuto      Engine = iota // chooses the engine automatically
+	EngineBacktrack               // backtracking VM
+	EngineLazyDFA                 // lazy DFA (and backtracking VM where DFA can't be used)
+	EngineDFA                     // minimized DFA built in advance (and backtracking VM where DFA can't be used)
+)
+
+// Dialect is integer to identify the syntax of the regular expression.
+type Dialect int
+
+// Each dialect is identified by a unique integer.
+const (
+	DialectDefault Dialect = iota // all the syntax the engine supports
+	DialectPOSIX                  // POSIX extended regular expression (leftmost-longest)
+)
+
+// LineTerminator is integer to identify the set of line terminators
+// which '.', '^' and '$' recognize.
+type LineTerminator int
+
+// Each set of line terminators is identified by a unique integer.
+const (
+	LineTerminatorLF      LineTerminator = iota // "\n"
+	LineTerminatorCRLF                          // "\r\n", "\r" and "\n"
+	LineTerminatorUnicode                       // "\r\n", "\n", "\v", "\f", "\r", U+0085, U+2028 and U+2029
+)
+
+// DefaultMaxRepeat is the maximum count of the counted repetition (e.g. a{2,5})
+// which is used when Options.MaxRepeat is 0.
+const DefaultMaxRepeat = 1000
+
+// DefaultMaxProgramSize is the maximum number of the instructions of the compiled program
+// which is used when Options.MaxProgramSize is 0.
+const DefaultMaxProgramSize = 100000
+
+// DefaultMaxDFAStates is the maximum number of the states of the DFA built for EngineDFA
+// which is used when Options.MaxDFAStates is 0.
+const DefaultMaxDFAStates = 10000
+
+// Options represents the settings to compile the regular expression.
+// The zero value is the default settings, which is used by Compile.
+type Options struct {
+	CaseInsensitive bool // same as (?i)
+	MultiLine       bool // same as (?m)
+	DotAll          bool // same as (?s)
+	FreeSpacing     bool // same as (?x)
+	LineTerminator  LineTerminator
+	Longest         bool // leftmost-longest matching instead of leftmost-first
+	Engine          Engine
+	MaxProgramSize  int // maximum number of instructions before the optimization (0 for DefaultMaxProgramSize)
+	MaxRepeat       int // maximum count of the counted repetition (0 for DefaultMaxRepeat)
+	Dialect         Dialect
+	MaxSteps        int           // maximum number of VM steps per matching (0 for unlimited)
+	Timeout         time.Duration // maximum duration per matching (0 for unlimited)
+	MaxThreads      int           // capacity of the VM thread stack (0 for vm.DefaultMaxThreads)
+	MaxDFAStates    int           // maximum number of the DFA states for EngineDFA (0 for DefaultMaxDFAStates)
+}
+
+// validate returns an error if the options are invalid or conflict with each other.
+func (o Options) validate() error {
+	switch {
+	case o.Engine < EngineAuto || o.Engine > EngineDFA:
+		return fmt.Errorf("unknown engine: %d", o.Engine)
+	case o.Dialect < DialectDefault || o.Dialect > DialectPOSIX:
+		return fmt.Errorf("unknown dialect: %d", o.Dialect)
+	case o.LineTerminator < LineTerminatorLF || o.LineTerminator > LineTerminatorUnicode:
+		return fmt.Errorf("unknown line terminator: %d", o.LineTerminator)
+	case o.MaxProgramSize < 0:
+		return fmt.Errorf("negative MaxProgramSize: %d", o.MaxProgramSize)
+	case o.MaxRepeat < 0:
+		return fmt.Errorf("negative MaxRepeat: %d", o.MaxRepeat)
+	case o.MaxSteps < 0:
+		return fmt.Errorf("negative MaxSteps: %d", o.MaxSteps)
+	case o.Timeout < 0:
+		return fmt.Errorf("negative Timeout: %v", o.Timeout)
+	case o.MaxThreads < 0:
+		return fmt.Errorf("negative MaxThreads: %d", o.MaxThreads)
+	case o.MaxDFAStates < 0:
+		return fmt.Errorf("negative MaxDFAStates: %d", o.MaxDFAStates)
+	case o.Dialect == DialectPOSIX && o.FreeSpacing:
+		return fmt.Errorf("FreeSpacing is not available in DialectPOSIX")
+	}
+	return nil
+}
+
+// flags returns the lexer flags corresponding to the options.
+func (o Options) flags() lexer.Flags {
+	var flags lexer.Flags
+	if o.CaseInsensitive {
+		flags |= lexer.CaseInsensitive
+	}
+	if o.MultiLine {
+		flags |= lexer.MultiLine
+	}
+	if o.DotAll {
+		flags |= lexer.DotAll
+	}
+	if o.FreeSpacing {
+		flags |= lexer.FreeSpacing
+	}
+	switch o.LineTerminator {
+	case LineTerminatorCRLF:
+		flags |= lexer.CRLF
+	case LineTerminatorUnicode:
+		flags |= lexer.UnicodeLineTerminators
+	}
+	if o.Dialect == DialectPOSIX {
+		flags |= lexer.POSIX
+	}
+	return flags
+}
+
+// longest returns whether the options require leftmost-longest matching.
+func (o Options) longest() bool {
+	return o.Longest || o.Dialect == DialectPOSIX
+}
+
+// maxRepeat returns the maximum count of the counted repetition allowed by the options.
+func (o Options) maxRepeat() int {
+	if o.MaxRepeat == 0 {
+		return DefaultMaxRepeat
+	}
+	return o.MaxRepeat
+}
+
+// maxProgramSize returns the maximum number of the instructions allowed by the options.
+func (o Options) maxProgramSize() int {
+	if o.MaxProgramSize == 0 {
+		return DefaultMaxProgramSize
+	}
+	return o.MaxProgramSize
+}
+
+// maxDFAStates returns the maximum number of the DFA states allowed by the options.
+func (o Options) maxDFAStates() int {
+	if o.MaxDFAStates == 0 {
+		return DefaultMaxDFAStates
+	}
+	return o.MaxDFAStates
+}
+
+// maxRepeat returns the largest count of the counted repetition in the AST.
+func maxRepeat(nd node.Node) int {
+	max := func(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	}
+
+	switch nd := nd.(type) {
+	case *node.Union:
+		return max(maxRepeat(nd.Ope1), maxRepeat(nd.Ope2))
+	case *node.Concat:
+		return max(maxRepeat(nd.Ope1), maxRepeat(nd.Ope2))
+	case *node.Star:
+		return maxRepeat(nd.Ope)
+	case *node.Plus:
+		return maxRepeat(nd.Ope)
+	case *node.Question:
+		return maxRepeat(nd.Ope)
+	case *node.Repeat:
+		return max(max(nd.Min, nd.Max), maxRepeat(nd.Ope))
+	case *node.Group:
+		return maxRepeat(nd.Ope)
+	}
+	return 0
+}
+
+// programSize returns the number of the instructions compiled from the AST (before the
+// optimization), without compiling it. The counted repetitions are not expanded, so the
+// size of the nested ones (e.g. ((a{1000}){1000})) is found without spending the time
+// and the memory for it. The size is saturated at math.MaxInt32.
+func programSize(nd node.Node) int {
+	add := func(a, b int) int {
+		if a+b > math.MaxInt32 {
+			return math.MaxInt32
+		}
+		return a + b
+	}
+	mul := func(a, b int) int {
+		if a != 0 && b > math.MaxInt32/a {
+			return math.MaxInt32
+		}
+		return a * b
+	}
+
+	switch nd := nd.(type) {
+	case *node.Union:
+		return add(add(programSize(nd.Ope1), programSize(nd.Ope2)), 3)
+	case *node.Concat:
+		return add(programSize(nd.Ope1), programSize(nd.Ope2))
+	case *node.Star:
+		if nd.Ope.Nullable() {
+			return add(programSize(nd.Ope), 4)
+		}
+		return add(programSize(nd.Ope), 3)
+	case *node.Plus:
+		if nd.Ope.Nullable() {
+			return add(programSize(nd.Ope), 3)
+		}
+		return add(programSize(nd.Ope), 2)
+	case *node.Question:
+		return add(programSize(nd.Ope), 2)
+	case *node.Repeat:
+		// See node.Repeat.expand.
+		n := programSize(nd.Ope)
+		size := mul(nd.Min, n)
+		switch {
+		case nd.Max == -1 && nd.Ope.Nullable():
+			size = add(size, add(n, 4))
+		case nd.Max == -1:
+			size = add(size, add(n, 3))
+		case nd.Max > nd.Min:
+			size = add(size, mul(nd.Max-nd.Min, add(n, 2)))
+		case nd.Max == 0:
+			size = 1
+		}
+		return size
+	case *node.Group:
+		return add(programSize(nd.Ope), 2)
+	}
+	return 1
+}
@@ -0,0 +1,52 @@
+package vmregex
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/parser"
+)
+
+// programSize must be the same as the number of the instructions actually compiled.
+func TestProgramSize(t *testing.T) {
+	patterns := []string{
+		`a`, `abc`, `a|b|c`, `(a|b)*c`, `(a*)*`, `(a*)+`, `a+`, `(?:ab)?`,
+		`a{3}`, `a{2,5}`, `a{2,}`, `(a*){2,}`, `a{0}`, `a{0,0}b`, `(a{2}){3}`,
+		`(?:a|())*`, `^(?m:$)[a-z]\d.`, `((a)?)+`, `(?:a?){0,3}`,
+	}
+	for _, p := range patterns {
+		ast, err := parser.NewParser(p).Parse()
+		if err != nil {
+			t.Fatalf("%q: %v", p, err)
+		}
+		ast = node.Simplify(ast)
+		if got, want := programSize(ast), ast.Compile().N; got != want {
+			t.Errorf("programSize(%q) = %d, want %d", p, got, want)
+		}
+	}
+}
+
+// The nested counted repetitions exceeding MaxProgramSize must be rejected before they are expanded.
+func TestMaxProgramSize(t *testing.T) {
+	tests := []struct {
+		re   string
+		opts Options
+		ok   bool
+	}{
+		{`(([ab]{1000}){1000})`, Options{}, false},
+		{`((a{1000}){1000}){1000}`, Options{}, false},
+		{`(?:(?:(?:a{1000}){1000}){1000}){1000}`, Options{}, false},
+		{`[ab]{1000}`, Options{}, true},
+		{`(a{100}){100}`, Options{}, true},
+		{`(a{100}){100}`, Options{MaxProgramSize: 1000}, false},
+		{strings.Repeat("a", 100), Options{MaxProgramSize: 100}, false},
+		{strings.Repeat("a", 99), Options{MaxProgramSize: 100}, true},
+	}
+	for _, tt := range tests {
+		_, err := CompileWithOptions(tt.re, tt.opts)
+		if (err == nil) != tt.ok {
+			t.Errorf("CompileWithOptions(%q, %+v) = %v, want ok %v", tt.re, tt.opts, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package vmregex
+
+import (
+	"github.com/8ayac/vm-regex-engine/node"
+	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/dfa"
+)
+
+// endAnchored returns whether every string matched by the AST ends at the end of the text.
+func endAnchored(nd node.Node) bool {
+	switch nd := nd.(type) {
+	case *node.End:
+		return nd.LT == nil
+	case *node.Concat:
+		return endAnchored(nd.Ope2)
+	case *node.Union:
+		return endAnchored(nd.Ope1) && endAnchored(nd.Ope2)
+	case *node.Group:
+		return endAnchored(nd.Ope)
+	}
+	return false
+}
+
+// matchEnds returns the positions in the input runes where any matched strings may end,
+// which are the positions to run the reversed VM from. They are the end of the text
+// if the regular expression is anchored there, the ends of the suffix literal,
+// or the positions found by the lazy DFA. If none of them is available,
+// it returns false.
+func (re *Regexp) matchEnds(input []rune, b *vm.Budget) ([]int, bool, error) {
+	text := input[:len(input)-1]
+	switch {
+	case re.endAnchored:
+		return []int{len(text)}, true, nil
+	case re.literals.suffix != "":
+		return literalEnds(text, []rune(re.literals.suffix)), true, nil
+	case re.lazy != nil:
+		ends, err := re.lazy.MatchEnds(text, b)
+		if err == dfa.ErrCacheThrashed {
+			return nil, false, nil
+		}
+		return ends, err == nil, err
+	}
+	return nil, false, nil
+}
+
+// literalEnds returns the positions in the text where lit ends, in ascending order.
+// lit must not be empty. As well as Knuth-Morris-Pratt algorithm, it skips the prefix of lit
+// known to match again after a mismatch, so that it never goes back in the text and
+// takes O(len(text)+len(lit)) time even if lit and the text repeat the same runes.
+func literalEnds(text, lit []rune) []int {
+	// fail[i] is the length of the longest proper prefix of lit[:i+1] which is also its suffix.
+	fail := make([]int, len(lit))
+	for i, k := 1, 0; i < len(lit); i++ {
+		for k > 0 && lit[i] != lit[k] {
+			k = fail[k-1]
+		}
+		if lit[i] == lit[k] {
+			k++
+		}
+		fail[i] = k
+	}
+
+	var ends []int
+	k := 0 // length of the prefix of lit matched so far
+	for sp, r := range text {
+		for k > 0 && r != lit[k] {
+			k = fail[k-1]
+		}
+		if r == lit[k] {
+			k++
+		}
+		if k == len(lit) {
+			ends = append(ends, sp+1)
+			k = fail[k-1]
+		}
+	}
+	return ends
+}
+
+// searchReverse finds the matched string in the input runes by running the reversed VM
+// from the positions where any matched strings may end, to find the leftmost position
+// where the matched string starts. Then the VM runs from there to find its end, following
+// the priority of the matching. If the positions are not available, it returns false.
+// The runs from the ends share the visited states (see vm.VM.SearchStart), so the whole
+// search takes linear time of the input however many ends there are.
+func (re *Regexp) searchReverse(input []rune, b *vm.Budget) (start, end int, ok bool, err error) {
+	ends, ok, err := re.matchEnds(input, b)
+	if !ok || err != nil {
+		return -1, -1, ok, err
+	}
+	start, err = re.reverse.SearchStart(input, ends, b)
+	if err != nil || start == -1 {
+		return -1, -1, true, err
+	}
+	end, err = re.runtime.RunWithBudget(input, start, b)
+	if err != nil {
+		return -1, -1, true, err
+	}
+	if end == -1 {
+		// Unreachable: both programs accept the same strings, since Reverse keeps
+		// the anchors as the conditions on the positions, and the memo (and Progress)
+		// of VM decides only which thread takes an empty iteration of a loop,
+		// never whether a match starts at the position. (e.g. '(|a)+b' and '(a*)*$')
+		// Just in case, leave it to the VM rather than return a wrong match.
+		return -1, -1, false, nil
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,142 @@
+package vmregex
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLiteralEnds(t *testing.T) {
+	// naive compares lit at every position of the text.
+	naive := func(text, lit []rune) []int {
+		var ends []int
+		for sp := 0; sp+len(lit) <= len(text); sp++ {
+			if string(text[sp:sp+len(lit)]) == string(lit) {
+				ends = append(ends, sp+len(lit))
+			}
+		}
+		return ends
+	}
+
+	tests := []struct{ text, lit string }{
+		{"aaaaa", "aa"}, {"abababa", "aba"}, {"aabaabaaab", "aabaaab"}, {"x.log.log", ".log"},
+		{"αβαβα", "αβα"}, {"", "a"}, {"a", "ab"},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		text, lit := make([]rune, rnd.Intn(20)), make([]rune, 1+rnd.Intn(4))
+		for j := range text {
+			text[j] = rune('a' + rnd.Intn(2))
+		}
+		for j := range lit {
+			lit[j] = rune('a' + rnd.Intn(2))
+		}
+		tests = append(tests, struct{ text, lit string }{string(text), string(lit)})
+	}
+	for _, tt := range tests {
+		want := naive([]rune(tt.text), []rune(tt.lit))
+		if got := literalEnds([]rune(tt.text), []rune(tt.lit)); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("literalEnds(%q, %q) = %v, want %v", tt.text, tt.lit, got, want)
+		}
+	}
+}
+
+// The reversed VM must run in linear time of the input, even if a match may end at
+// every position and the reversed runs from them go back to the top of the input.
+func TestSearchReverseLinear(t *testing.T) {
+	const n = 20000
+	tests := []struct {
+		re    string
+		input string
+	}{
+		{`[ab]*b`, strings.Repeat("b", n)},
+		{`[ab]*b`, strings.Repeat("a", n) + "b"},
+		{`(a|b)*c`, strings.Repeat("ab", n/2) + "c"},
+		{`\w+\.log`, strings.Repeat(".log", n/4)},
+		{`[ab]*(b|cd)`, strings.Repeat("ab", n/2)},
+		{`x*y?z`, strings.Repeat("xz", n/2)},
+	}
+	for _, tt := range tests {
+		for _, longest := range []bool{false, true} {
+			re, err := CompileWithOptions(tt.re, Options{Longest: longest, MaxSteps: 50 * n, MaxThreads: 2 * n})
+			if err != nil {
+				t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+			}
+			if re.reverse == nil {
+				t.Fatalf("%q: no reversed VM", tt.re)
+			}
+			start, end, err := re.MatchContext(context.Background(), tt.input)
+			if err != nil {
+				t.Errorf("%q (longest %v) on %d runes: %v", tt.re, longest, len(tt.input), err)
+				continue
+			}
+			g := regexp.MustCompile(tt.re)
+			if longest {
+				g.Longest()
+			}
+			want := []int{0, 0}
+			if m := g.FindStringIndex(tt.input); m != nil {
+				want = m
+			}
+			if start != want[0] || end != want[1] {
+				t.Errorf("%q (longest %v): [%d, %d], want %v", tt.re, longest, start, end, want)
+			}
+		}
+	}
+}
+
+// The reversed VM and VM must agree on the loops whose operand can match the empty string,
+// because they decide only which thread wins, not whether a match starts at a position.
+// So the search never falls back to VM, and finds the same match as Go's regexp package.
+func TestSearchReverseEmptyLoop(t *testing.T) {
+	tests := []struct {
+		re    string
+		input string
+	}{
+		{`(|a)+b`, "aab"},
+		{`(|a)*b`, "cab"},
+		{`(a*)+b`, "xaab"},
+		{`(a*)*$`, "baa"},
+		{`(a*|b)*c`, "abac"},
+		{`(?:b|(?:|a))+c`, "bac"},
+		{`(?:(?:b|)(?:|c))+d`, "bcd"},
+		{`(?:(a*)(b*))*c`, "abbac"},
+		{`(^|x)+y`, "xxy"},
+		{`(^|x)+y`, "axxy"},
+		{`(a|$)+$`, "ba"},
+		{`((a)?)+b`, "b"},
+		{`((a)?)+b`, "c"},
+		{`(|a)+`, "a"},
+		{`(a*)*`, "b"},
+	}
+	for _, tt := range tests {
+		for _, longest := range []bool{false, true} {
+			re, err := CompileWithOptions(tt.re, Options{Longest: longest})
+			if err != nil {
+				t.Fatalf("CompileWithOptions(%q): %v", tt.re, err)
+			}
+			if re.reverse == nil {
+				t.Fatalf("%q: no reversed VM", tt.re)
+			}
+			start, end, ok, err := re.searchReverse(append([]rune(tt.input), '\x00'), nil)
+			if !ok || err != nil {
+				t.Errorf("%q (longest %v) on %q: searchReverse = %v, %v", tt.re, longest, tt.input, ok, err)
+				continue
+			}
+			g := regexp.MustCompile(tt.re)
+			if longest {
+				g.Longest()
+			}
+			want := []int{-1, -1}
+			if m := g.FindStringIndex(tt.input); m != nil {
+				want = m
+			}
+			if start != want[0] || end != want[1] {
+				t.Errorf("%q (longest %v) on %q: [%d, %d], want %v", tt.re, longest, tt.input, start, end, want)
+			}
+		}
+	}
+}
@@ -2,47 +2,382 @@
 package vmregex
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/8ayac/vm-regex-engine/bytecode"
+	"github.com/8ayac/vm-regex-engine/node"
 	"github.com/8ayac/vm-regex-engine/parser"
 	"github.com/8ayac/vm-regex-engine/vm"
+	"github.com/8ayac/vm-regex-engine/vm/dfa"
 	"github.com/8ayac/vm-regex-engine/vm/instruction"
+	"github.com/8ayac/vm-regex-engine/vm/onepass"
 	"github.com/8ayac/vm-regex-engine/vm/opcode"
 )
 
-// Regexp has a VM and regexp string.
+// Regexp has a VM, regexp string and the options used to compile it.
+// If the regular expression can be executed by DFA, Regexp has also the lazy DFA
+// (or the DFA built in advance for EngineDFA), which is used instead of VM
+// when only whether the string matches is needed.
+// If the regular expression is anchored and one-pass, Regexp has also the one-pass
+// executor, which is used instead of VM to find the match and its captures.
+// The literals which any matched string contains are used to reject the input
+// which can't match before running them.
+// Regexp may have also the reversed VM, which finds where the matched string starts
+// from where it ends, so that the VM needs to run only from there.
 type Regexp struct {
-	regexp  string
-	runtime *vm.VM
+	regexp   string
+	opts     Options
+	groups   int
+	literals *literals
+	required []string     // literals required to match (cached from literals)
+	prog     *bytecode.BC // compiled program
+	rprog    *bytecode.BC // compiled program of the reversed regular expression (may be nil)
+	runtime  *vm.VM
+	reverse  *vm.VM
+	lazy     *dfa.Lazy
+	full     *dfa.DFA
+	onepass  *onepass.OnePass
+
+	endAnchored bool // whether every matched string ends at the end of the text
 }
 
 // NewRegexp return a new Regexp.
+// If the regular expression has a syntax error, NewRegexp aborts with its message.
 func NewRegexp(re string) *Regexp {
-	psr := parser.NewParser(re)
-	ast := psr.GetAST()
+	r, err := CompileWithOptions(re, Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return r
+}
+
+// Compile is a wrapper function of NewRegexp().
+func Compile(re string) *Regexp {
+	return NewRegexp(re)
+}
+
+// CompileWithOptions returns a new Regexp compiled with the argument options.
+// If the options are invalid or the regular expression can't be compiled
+// with them, it returns the error instead.
+func CompileWithOptions(re string, opts Options) (*Regexp, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	psr := parser.NewParserWithFlags(re, opts.flags())
+	ast, err := psr.Parse()
+	if err != nil {
+		return nil, err
+	}
+	if n := maxRepeat(ast); n > opts.maxRepeat() {
+		return nil, fmt.Errorf("repeat count %d exceeds MaxRepeat %d", n, opts.maxRepeat())
+	}
+	ast = node.Simplify(ast)
+	if n := programSize(ast) + 1; n > opts.maxProgramSize() {
+		return nil, fmt.Errorf("program size %d exceeds MaxProgramSize %d", n, opts.maxProgramSize())
+	}
+
 	bc := ast.Compile()
 	bc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), bc.N)
 	bc.Optimize()
 
-	return &Regexp{
-		regexp:  re,
-		runtime: vm.NewVM(bc),
+	r := &Regexp{
+		regexp:   re,
+		opts:     opts,
+		groups:   psr.NumGroups(),
+		literals: findLiterals(ast),
+		prog:     bc,
+
+		endAnchored: endAnchored(ast),
+	}
+	if opts.Engine == EngineAuto && r.literals.prefix == "" {
+		rbc := node.CompileReverse(ast)
+		rbc.AddInst(instruction.NewInst(opcode.Match, 0, nil, nil), rbc.N)
+		rbc.Optimize()
+		r.rprog = rbc
+	}
+	if err := r.build(); err != nil {
+		return nil, err
 	}
+	return r, nil
 }
 
-// Compile is a wrapper function of NewRegexp().
-func Compile(re string) *Regexp {
-	return NewRegexp(re)
+// build builds the executors of the compiled programs following the options,
+// except the DFA which has been decoded by UnmarshalBinary.
+func (r *Regexp) build() error {
+	// The slots for the groups are allocated by NumSubexp (see MatchSubmatchContext).
+	nslots := 2 * (r.groups + 1)
+	if err := bytecode.VerifySlots(r.prog, nslots); err != nil {
+		return err
+	}
+	if r.rprog != nil {
+		if err := bytecode.VerifySlots(r.rprog, nslots); err != nil {
+			return err
+		}
+	}
+
+	opts := r.opts
+	runtime, err := vm.NewVM(r.prog)
+	if err != nil {
+		return err
+	}
+	runtime.SetLongest(opts.longest())
+	if opts.MaxThreads > 0 {
+		runtime.SetMaxThreads(opts.MaxThreads)
+	}
+	r.runtime = runtime
+	r.required = requiredLiterals(r.literals)
+
+	switch opts.Engine {
+	case EngineAuto:
+		r.lazy, _ = dfa.NewLazy(r.prog)
+		r.onepass, _ = onepass.New(r.prog)
+	case EngineLazyDFA:
+		r.lazy, err = dfa.NewLazy(r.prog)
+		if err != nil {
+			return fmt.Errorf("EngineLazyDFA is not available: %v", err)
+		}
+	case EngineDFA:
+		if r.full != nil {
+			break
+		}
+		r.full, err = dfa.New(r.prog, opts.maxDFAStates())
+		if err != nil {
+			return fmt.Errorf("EngineDFA is not available: %v", err)
+		}
+	}
+
+	// The reversed VM is worth running only if where the matched strings end can be found.
+	if r.rprog != nil && (r.endAnchored || r.literals.suffix != "" || r.lazy != nil) {
+		if r.reverse, err = vm.NewVM(r.rprog); err != nil {
+			return err
+		}
+		r.reverse.SetReverse(true)
+		r.reverse.SetLongest(true)
+		if opts.MaxThreads > 0 {
+			r.reverse.SetMaxThreads(opts.MaxThreads)
+		}
+	}
+	return nil
 }
 
+// Errors which stop the matching.
+var (
+	// ErrMatchBudgetExceeded is returned when a matching exceeds Options.MaxSteps or Options.Timeout.
+	ErrMatchBudgetExceeded = vm.ErrMatchBudgetExceeded
+
+	// ErrThreadOverflow is returned when a matching exceeds Options.MaxThreads.
+	ErrThreadOverflow = vm.ErrThreadOverflow
+)
+
 // Match returns whether the input string matches the regular expression.
+// The return values are the byte offsets of the start and the end of the matched string.
+// If the matching exceeds the budget or the capacity of threads in the options,
+// Match regards it as not matched.
 func (re *Regexp) Match(s string) (start, end int) {
-	result := 0
-	for i := 0; i < len(s); i++ {
-		result = re.runtime.Run(s[i:] + "\x00")
-		if result != -1 {
-			start = i
-			end = result + i
-			return
+	start, end, _ = re.MatchContext(context.Background(), s)
+	return
+}
+
+// MatchContext is the same as Match, but stops the matching when ctx is done
+// or the matching exceeds the budget or the capacity of threads in the options,
+// and then returns the error. The error is ErrMatchBudgetExceeded, ErrThreadOverflow
+// or the one returned by ctx.Err().
+func (re *Regexp) MatchContext(ctx context.Context, s string) (start, end int, err error) {
+	if !re.mayMatch(s) {
+		return 0, 0, nil
+	}
+	if re.onepass != nil {
+		m, err := re.MatchSubmatchContext(ctx, s)
+		if err != nil || m == nil {
+			return 0, 0, err
 		}
+		return m[0], m[1], nil
 	}
-	return
+
+	input := append([]rune(s), '\x00')
+	b := vm.NewBudget(ctx, re.opts.MaxSteps, re.opts.Timeout)
+	if re.reverse != nil {
+		start, end, ok, err := re.searchReverse(input, b)
+		if err != nil {
+			return 0, 0, err
+		}
+		if ok {
+			if start == -1 {
+				return 0, 0, nil
+			}
+			offsets := byteOffsets(s)
+			return offsets[start], offsets[end], nil
+		}
+	}
+	start, end, err = re.runtime.Search(input, b)
+	if err != nil || start == -1 {
+		return 0, 0, err
+	}
+	offsets := byteOffsets(s)
+	return offsets[start], offsets[end], nil
+}
+
+// String returns the source text of the regular expression.
+func (re *Regexp) String() string {
+	return re.regexp
+}
+
+// Options returns the options used to compile the regular expression.
+func (re *Regexp) Options() Options {
+	return re.opts
+}
+
+// Program returns the compiled program of the regular expression.
+// It must not be modified, because the executors share it.
+func (re *Regexp) Program() *bytecode.BC {
+	return re.prog
+}
+
+// NumSubexp returns the number of the capturing groups in the regular expression.
+func (re *Regexp) NumSubexp() int {
+	return re.groups
+}
+
+// MatchSubmatch returns the byte offsets of the matched string and the strings
+// matched by the capturing groups in the input string, or nil if not matched.
+// The offsets m[0] and m[1] are the start and the end of the matched string,
+// and m[2*i] and m[2*i+1] are the ones of the i-th group (-1 if the group didn't match).
+// If the matching exceeds the budget or the capacity of threads in the options,
+// MatchSubmatch regards it as not matched.
+func (re *Regexp) MatchSubmatch(s string) []int {
+	m, _ := re.MatchSubmatchContext(context.Background(), s)
+	return m
+}
+
+// MatchSubmatchContext is the same as MatchSubmatch, but stops the matching when ctx is done
+// or the matching exceeds the budget or the capacity of threads in the options,
+// and then returns the error as well as MatchContext.
+func (re *Regexp) MatchSubmatchContext(ctx context.Context, s string) ([]int, error) {
+	if !re.mayMatch(s) {
+		return nil, nil
+	}
+	input := append([]rune(s), '\x00')
+	b := vm.NewBudget(ctx, re.opts.MaxSteps, re.opts.Timeout)
+
+	var caps []int
+	var err error
+	if re.onepass != nil {
+		caps, err = re.onepass.Match(input, b)
+	} else {
+		caps, err = re.runtime.SearchSubmatch(input, b)
+	}
+	if err != nil || caps == nil {
+		return nil, err
+	}
+
+	offsets := byteOffsets(s)
+	m := make([]int, 2*(re.groups+1))
+	for i := range m {
+		m[i] = -1
+		if i < len(caps) && caps[i] != -1 {
+			m[i] = offsets[caps[i]]
+		}
+	}
+	return m, nil
+}
+
+// MatchString returns whether the input string has a string matching the regular expression.
+// If the matching exceeds the budget or the capacity of threads in the options,
+// MatchString regards it as not matched.
+func (re *Regexp) MatchString(s string) bool {
+	matched, _ := re.MatchStringContext(context.Background(), s)
+	return matched
+}
+
+// MatchStringContext is the same as MatchString, but stops the matching when ctx is done
+// or the matching exceeds the budget or the capacity of threads in the options,
+// and then returns the error as well as MatchContext.
+func (re *Regexp) MatchStringContext(ctx context.Context, s string) (bool, error) {
+	if !re.mayMatch(s) {
+		return false, nil
+	}
+	input := append([]rune(s), '\x00')
+	b := vm.NewBudget(ctx, re.opts.MaxSteps, re.opts.Timeout)
+	if re.full != nil {
+		return re.full.Match(input[:len(input)-1], b)
+	}
+	if re.lazy != nil {
+		matched, err := re.lazy.Match(input[:len(input)-1], b)
+		if err != dfa.ErrCacheThrashed {
+			return matched, err
+		}
+	}
+	if re.onepass != nil {
+		caps, err := re.onepass.Match(input, b)
+		return caps != nil, err
+	}
+	start, _, err := re.runtime.Search(input, b)
+	return start != -1, err
+}
+
+// DFAStateCount returns the number of the states of the minimized DFA,
+// or 0 if the Regexp is not compiled with EngineDFA.
+// It helps to decide whether the DFA is too large to keep, and to fall back to
+// the other engine by compiling again.
+func (re *Regexp) DFAStateCount() int {
+	if re.full == nil {
+		return 0
+	}
+	return re.full.NumStates()
+}
+
+// LiteralPrefix returns the literal which any string matched by the regular expression
+// starts with, and whether the regular expression matches only the literal.
+func (re *Regexp) LiteralPrefix() (prefix string, complete bool) {
+	return re.literals.prefix, re.literals.exact && !re.literals.anchor
+}
+
+// RequiredLiterals returns the literals which any string matched by the regular expression
+// contains, the longer ones first. If the input string doesn't contain any of them,
+// the matching fails without running the engine.
+func (re *Regexp) RequiredLiterals() []string {
+	return append([]string(nil), re.required...)
+}
+
+// mayMatch returns whether the input string contains all the literals required to match.
+func (re *Regexp) mayMatch(s string) bool {
+	for _, lit := range re.required {
+		if !strings.Contains(s, lit) {
+			return false
+		}
+	}
+	return true
+}
+
+// byteOffsets returns the byte offsets of each rune in the string s.
+// The last element is the length of s, which is the offset of the end of s.
+func byteOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+// metacharacters is the characters which have a special meaning in the regular expression.
+// '\x00' is one of them, because the lexer takes it as the end of the regular expression.
+const metacharacters = `\.+*?()|[]{}^$#` + "\x00"
+
+// QuoteMeta returns a string that escapes all the metacharacters inside the argument text,
+// so that the returned string is a regular expression which matches the literal text.
+// The white spaces are also escaped not to be ignored in the free-spacing mode.
+func QuoteMeta(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(metacharacters, r) || unicode.IsSpace(r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
@@ -0,0 +1,36 @@
+package vmregex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// The regular expression of QuoteMeta must match exactly the text, in the free-spacing mode too.
+func TestQuoteMeta(t *testing.T) {
+	texts := []string{
+		"", "abc", `\.+*?()|[]{}^$#`, "1.5 + 2", "a # not a comment", "[a-z]{2}",
+		"tab\there", "new\nline", "\r\n", "\v\f", "\u0085\u00a0\u2028\u3000", "-&~:=<>!,'\"/",
+		`C:\dir\x41\p{L}`, "(?i)", "αβγ", "\x00", "a\x00b",
+	}
+	rnd := rand.New(rand.NewSource(1))
+	runes := []rune(`\.+*?()|[]{}^$#-&~ ab` + "\t\n\r\u00a0\u3000é")
+	for i := 0; i < 200; i++ {
+		s := make([]rune, rnd.Intn(12))
+		for j := range s {
+			s[j] = runes[rnd.Intn(len(runes))]
+		}
+		texts = append(texts, string(s))
+	}
+
+	for _, flags := range []string{"", "(?x)", "(?xms)"} {
+		for _, s := range texts {
+			re := Compile(flags + "^" + QuoteMeta(s) + "$")
+			if start, end := re.Match(s); start != 0 || end != len(s) {
+				t.Errorf("%sQuoteMeta(%q) = %q matches [%d, %d] of the text", flags, s, QuoteMeta(s), start, end)
+			}
+			if _, end := re.Match(s + "x"); end != 0 {
+				t.Errorf("%sQuoteMeta(%q) = %q matches %q", flags, s, QuoteMeta(s), s+"x")
+			}
+		}
+	}
+}